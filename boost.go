@@ -0,0 +1,318 @@
+package psx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// A line from the boost server couldn't be parsed.
+	BoostSyntaxError = errors.New("Malformed boost line")
+)
+
+// The port the PSX boost server listens on by default.  The main server is on
+// 10747.
+const DefaultBoostPort = 10749
+
+// BoostData is a single update from the PSX boost server - the high rate
+// flight dynamics stream used for visuals and motion platforms.
+//
+// On the wire, each update is a line holding an on-ground flag (G or F),
+// immediately followed by the flight deck altitude, then the remaining
+// fields, ; delimited, in the order below:
+//
+//	F35000.0;270.52;2.51;-0.20;51.4700000;-0.4543000;83.0
+type BoostData struct {
+	OnGround  bool    // the aircraft is on the ground
+	Altitude  float64 // flight deck altitude, feet
+	Heading   float64 // true heading, degrees
+	Pitch     float64 // degrees, nose up positive
+	Bank      float64 // degrees, right wing down positive
+	Latitude  float64 // degrees, north positive
+	Longitude float64 // degrees, east positive
+	Elevation float64 // ground elevation below the aircraft, feet (if sent)
+
+	Received time.Time // when the update arrived (zero when parsed directly)
+	Raw      string    // the line as received, sans line end (empty when parsed directly)
+}
+
+// Parse a boost server line.
+func ParseBoost(line string) (data BoostData, err error) {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 {
+		return data, BoostSyntaxError
+	}
+	switch line[0] {
+	case 'G':
+		data.OnGround = true
+	case 'F':
+	default:
+		return data, BoostSyntaxError
+	}
+	fields := strings.Split(line[1:], ";")
+	if len(fields) < 6 {
+		return data, BoostSyntaxError
+	}
+	values := []*float64{&data.Altitude, &data.Heading, &data.Pitch, &data.Bank, &data.Latitude, &data.Longitude, &data.Elevation}
+	for i, field := range fields {
+		if i >= len(values) {
+			break
+		}
+		if *values[i], err = strconv.ParseFloat(field, 64); err != nil {
+			return BoostData{}, BoostSyntaxError
+		}
+	}
+	return data, nil
+}
+
+// Encode the update as a boost server line, without the line ending.
+func (data BoostData) Line() string {
+	var line strings.Builder
+	if data.OnGround {
+		line.WriteByte('G')
+	} else {
+		line.WriteByte('F')
+	}
+	for i, val := range []float64{data.Altitude, data.Heading, data.Pitch, data.Bank, data.Latitude, data.Longitude, data.Elevation} {
+		if i > 0 {
+			line.WriteByte(';')
+		}
+		line.WriteString(strconv.FormatFloat(val, 'f', -1, 64))
+	}
+	return line.String()
+}
+
+// BoostHooks are called with every update received from the boost server.
+type BoostHook func(bconn *BoostConnection, data BoostData)
+
+// BoostReconnectHooks are called by BoostConnection.RunWithReconnect before
+// it waits to retry - see ReconnectHook.
+type BoostReconnectHook func(bconn *BoostConnection, attempt int, delay time.Duration, err error)
+
+// BoostConnection is a client for the PSX boost server.
+//
+// The boost server needs no handshake - it streams a line for every flight
+// dynamics update to every client.  Lines that can't be parsed are skipped.
+//
+// BoostConnection reports the same ConnState lifecycle as Connection: New
+// once connected, Running from the first update, then Ended if the server
+// hung up or Failed on any other error, and finally ListenerExited - or just
+// Disconnected if Run was cancelled.  There are no Load states.
+type BoostConnection struct {
+	// Hostname & Port to connect to
+	Server string
+
+	// Called with every update, from the reading goroutine.
+	OnBoost BoostHook
+
+	// Reconnection pacing used by RunWithReconnect.
+	Backoff Backoff
+	// Called by RunWithReconnect before waiting to reconnect.
+	OnReconnect BoostReconnectHook
+
+	// mu guards conn, the state, the latest update and the watchers.
+	mu             sync.Mutex
+	conn           net.Conn
+	dialing        bool // set whilst Run is establishing conn
+	state          ConnState
+	stateListeners []StateChangeHook
+	latest         BoostData
+	received       bool // set once this connection has delivered an update
+	watchers       []chan BoostData
+}
+
+// Initialise a BoostConnection to the boost server at server (host:port).
+func NewBoostConnection(server string) (bconn *BoostConnection, err error) {
+	bconn = new(BoostConnection)
+	bconn.Server = server
+	bconn.Backoff = DefaultBackoff
+	return bconn, nil
+}
+
+// Returns the current connection state.
+func (bconn *BoostConnection) State() ConnState {
+	bconn.mu.Lock()
+	defer bconn.mu.Unlock()
+	return bconn.state
+}
+
+// Register fn to be called on every connection state transition, from the
+// goroutine causing it - usually the one running Run.
+func (bconn *BoostConnection) OnStateChange(fn StateChangeHook) {
+	bconn.mu.Lock()
+	defer bconn.mu.Unlock()
+	bconn.stateListeners = append(bconn.stateListeners, fn)
+}
+
+// move to newState, notifying the state listeners if it's a change.
+func (bconn *BoostConnection) setState(newState ConnState) {
+	bconn.mu.Lock()
+	oldState := bconn.state
+	if oldState == newState {
+		bconn.mu.Unlock()
+		return
+	}
+	bconn.state = newState
+	listeners := bconn.stateListeners
+	bconn.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(oldState, newState)
+	}
+}
+
+// Returns the latest update, and whether there has been one.
+func (bconn *BoostConnection) Latest() (data BoostData, found bool) {
+	bconn.mu.Lock()
+	defer bconn.mu.Unlock()
+	return bconn.latest, !bconn.latest.Received.IsZero()
+}
+
+// Deliver updates on a channel until ctx is cancelled, when the channel is
+// closed.
+//
+// Boost updates arrive quickly and only the latest matters, so the channel
+// holds a single update - if the consumer falls behind, older updates are
+// replaced by newer ones.
+func (bconn *BoostConnection) Watch(ctx context.Context) <-chan BoostData {
+	ch := make(chan BoostData, 1)
+	bconn.mu.Lock()
+	bconn.watchers = append(bconn.watchers, ch)
+	bconn.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		bconn.mu.Lock()
+		defer bconn.mu.Unlock()
+		for i, watcher := range bconn.watchers {
+			if watcher == ch {
+				bconn.watchers = append(bconn.watchers[:i:i], bconn.watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+// record and deliver an update.
+func (bconn *BoostConnection) deliver(data BoostData) {
+	bconn.mu.Lock()
+	bconn.latest = data
+	bconn.received = true
+	for _, ch := range bconn.watchers {
+		// replace anything the consumer hasn't picked up yet.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+	bconn.mu.Unlock()
+
+	if bconn.OnBoost != nil {
+		bconn.OnBoost(bconn, data)
+	}
+}
+
+// Connect to the boost server and read updates until the connection ends.
+//
+// Returns ctx.Err() if the context was cancelled, or otherwise the error
+// that ended the connection (io.EOF if the server closed it).
+func (bconn *BoostConnection) Run(ctx context.Context) error {
+	bconn.mu.Lock()
+	if bconn.conn != nil || bconn.dialing {
+		bconn.mu.Unlock()
+		return ConnectionBusyError
+	}
+	bconn.dialing = true
+	bconn.mu.Unlock()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", bconn.Server)
+	bconn.mu.Lock()
+	bconn.dialing = false
+	if err != nil {
+		bconn.mu.Unlock()
+		return err
+	}
+	bconn.conn = conn
+	bconn.received = false
+	bconn.mu.Unlock()
+	bconn.setState(StateNew)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		var line string
+		line, err = reader.ReadString('\n')
+		if data, parseErr := ParseBoost(line); parseErr == nil {
+			data.Received = time.Now()
+			data.Raw = strings.TrimSpace(line)
+			bconn.setState(StateRunning)
+			bconn.deliver(data)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		bconn.Disconnect()
+		bconn.setState(StateDisconnected)
+		return ctx.Err()
+	}
+	if err == io.EOF {
+		bconn.setState(StateEnded)
+	} else {
+		bconn.setState(StateFailed)
+	}
+	bconn.Disconnect()
+	bconn.setState(StateListenerExited)
+	return err
+}
+
+// Close the connection to the boost server, if there is one.
+func (bconn *BoostConnection) Disconnect() {
+	bconn.mu.Lock()
+	conn := bconn.conn
+	bconn.conn = nil
+	bconn.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Run the connection, reconnecting whenever it is lost, until ctx is
+// cancelled.
+//
+// Reconnection attempts are paced according to bconn.Backoff, as for
+// Connection.RunWithReconnect.  The consecutive failure count is reset once
+// a connection delivers an update.
+func (bconn *BoostConnection) RunWithReconnect(ctx context.Context) error {
+	return bconn.Backoff.supervise(ctx, func() (established bool, err error) {
+		err = bconn.Run(ctx)
+		bconn.mu.Lock()
+		defer bconn.mu.Unlock()
+		return bconn.received, err
+	}, func(attempt int, delay time.Duration, err error) {
+		if bconn.OnReconnect != nil {
+			bconn.OnReconnect(bconn, attempt, delay, err)
+		}
+	})
+}
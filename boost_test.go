@@ -0,0 +1,104 @@
+package psx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBoost(t *testing.T) {
+	data, err := ParseBoost("F35000.5;270.52;2.51;-0.2;51.47;-0.4543;83\r\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	want := BoostData{Altitude: 35000.5, Heading: 270.52, Pitch: 2.51, Bank: -0.2, Latitude: 51.47, Longitude: -0.4543, Elevation: 83}
+	if data != want {
+		t.Errorf("Parsed %+v, expected %+v", data, want)
+	}
+	if data.Line() != "F35000.5;270.52;2.51;-0.2;51.47;-0.4543;83" {
+		t.Errorf("Reencoded as %q", data.Line())
+	}
+
+	data, err = ParseBoost("G120;90;0;0;1;2")
+	if err != nil || !data.OnGround || data.Elevation != 0 {
+		t.Errorf("Short ground line parsed as %+v, %v", data, err)
+	}
+
+	for _, line := range []string{"", "X1;2;3;4;5;6", "F1;2;3", "F1;2;3;4;5;x"} {
+		if _, err := ParseBoost(line); !errors.Is(err, BoostSyntaxError) {
+			t.Errorf("%q: got %v, expected a syntax error", line, err)
+		}
+	}
+}
+
+func TestBoostConnection(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		c.Write([]byte("garbage\r\nF1000;90;0;0;1;2\r\nG0;180;0;0;1;2\r\n"))
+		time.Sleep(50 * time.Millisecond)
+	})
+	bconn, _ := NewBoostConnection(addr)
+	var states []string
+	bconn.OnStateChange(func(_, newState ConnState) {
+		states = append(states, newState.String())
+	})
+	var hooked []BoostData
+	bconn.OnBoost = func(_ *BoostConnection, data BoostData) {
+		hooked = append(hooked, data)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := bconn.Watch(ctx)
+
+	if err := bconn.Run(context.Background()); err == nil {
+		t.Errorf("Run returned nil once the server hung up")
+	}
+	if got := strings.Join(states, ","); got != "New,Running,Ended,ListenerExited" {
+		t.Errorf("Unexpected state changes: %s", got)
+	}
+	if len(hooked) != 2 || hooked[0].Altitude != 1000 || !hooked[1].OnGround {
+		t.Errorf("Unexpected updates delivered: %+v", hooked)
+	}
+	// the channel only keeps the latest.
+	select {
+	case data := <-updates:
+		if data.Heading != 180 || data.Received.IsZero() {
+			t.Errorf("Watch delivered %+v", data)
+		}
+	default:
+		t.Errorf("Watch delivered nothing")
+	}
+	if latest, found := bconn.Latest(); !found || latest.Heading != 180 {
+		t.Errorf("Latest returned %+v, %v", latest, found)
+	}
+	cancel()
+	for range updates {
+	}
+}
+
+func TestBoostReconnect(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		c.Write([]byte("F1000;90;0;0;1;2\r\n"))
+	})
+	bconn, _ := NewBoostConnection(addr)
+	bconn.Backoff = Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := 0
+	bconn.OnBoost = func(_ *BoostConnection, _ BoostData) {
+		updates++
+		if updates == 3 {
+			cancel()
+		}
+	}
+	if err := bconn.RunWithReconnect(ctx); err != context.Canceled {
+		t.Errorf("RunWithReconnect returned %v", err)
+	}
+	if updates < 3 {
+		t.Errorf("Only %d updates delivered across reconnects", updates)
+	}
+	if bconn.State() != StateDisconnected {
+		t.Errorf("Cancelled connection finished in state %s", bconn.State())
+	}
+}
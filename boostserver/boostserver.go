@@ -0,0 +1,298 @@
+// Provides a server speaking the PSX boost protocol.
+//
+// A Server sends boost lines to any number of clients (visuals, image
+// generators, motion platforms).  The updates can come from an upstream
+// boost server, relayed through a psx.BoostConnection, or be synthesised
+// from the PiBaHeAlTas variable of an ordinary psx.Connection - handy for
+// testing visuals against a recorded capture.
+//
+// Each client can be rate limited independently.  A client that can't keep
+// up is simply sent the newest update when it's next ready, never a backlog.
+package boostserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/vars"
+)
+
+var (
+	// Returned by Serve once the Server has been closed.
+	ServerClosedError = errors.New("boostserver: closed")
+)
+
+// Server fans boost updates out to its clients.
+//
+// Use New to initialise a Server.
+type Server struct {
+	// The minimum time between updates sent to each client.  Zero sends
+	// every update.
+	MinInterval time.Duration
+	// If set, overrides MinInterval for individual clients, by their remote
+	// address.
+	ClientInterval func(addr net.Addr) time.Duration
+
+	mu        sync.Mutex
+	clients   map[*client]bool
+	latest    string // the last line published, sent to new clients
+	listeners []net.Listener
+	closed    bool
+}
+
+// Create a new Server with no clients.
+func New() *Server {
+	s := new(Server)
+	s.clients = make(map[*client]bool)
+	return s
+}
+
+// Send an update to every client.  Updates received from a boost server
+// are forwarded exactly as received - others are encoded with Line.
+func (s *Server) Publish(data psx.BoostData) {
+	line := data.Raw
+	if line == "" {
+		line = data.Line()
+	}
+	s.mu.Lock()
+	s.latest = line
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.offer(line)
+	}
+}
+
+// Relay the updates from an upstream boost server, passing its lines
+// through untouched.  This installs the BoostConnection's OnBoost hook - run
+// it (typically with RunWithReconnect) alongside Serve.
+func (s *Server) RelayFrom(bconn *psx.BoostConnection) {
+	bconn.OnBoost = func(_ *psx.BoostConnection, data psx.BoostData) {
+		s.Publish(data)
+	}
+}
+
+// Convert a position to a boost update.  PiBaHeAlTas doesn't say whether
+// the aircraft is on the ground, or the elevation below it, so those are
+// left unset.
+func FromPosition(pos vars.Position) psx.BoostData {
+	return psx.BoostData{
+		Altitude:  pos.Altitude,
+		Heading:   pos.HeadingDeg(),
+		Pitch:     pos.PitchDeg(),
+		Bank:      pos.BankDeg(),
+		Latitude:  pos.LatitudeDeg(),
+		Longitude: pos.LongitudeDeg(),
+	}
+}
+
+// Synthesise updates from the PiBaHeAlTas updates received by pconn.
+// Returns the hook's ID so it can be removed again.
+func (s *Server) SynthesizeFrom(pconn *psx.Connection) psx.HookID {
+	return vars.OnPosition(pconn, func(pos vars.Position) {
+		s.Publish(FromPosition(pos))
+	})
+}
+
+// Listen on addr and serve clients until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Accept and serve clients on l until ctx is cancelled or the Server is
+// closed.  l is closed when Serve returns.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ServerClosedError
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			l.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ServerClosedError
+			}
+			return err
+		}
+		s.addClient(conn)
+	}
+}
+
+// Returns the number of connected clients.
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Stop serving and disconnect all clients.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = nil
+	clients := s.clients
+	s.clients = make(map[*client]bool)
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for c := range clients {
+		c.close()
+	}
+}
+
+// register a new client, and start it off with the latest update.
+func (s *Server) addClient(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	interval := s.MinInterval
+	if s.ClientInterval != nil {
+		interval = s.ClientInterval(conn.RemoteAddr())
+	}
+	c := &client{
+		server:   s,
+		conn:     conn,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[c] = true
+	latest := s.latest
+	s.mu.Unlock()
+
+	if latest != "" {
+		c.offer(latest)
+	}
+	go c.writeLoop()
+	go c.readLoop()
+}
+
+// forget a client that has gone away.
+func (s *Server) removeClient(c *client) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+}
+
+// client is a single boost client.
+type client struct {
+	server   *Server
+	conn     net.Conn
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending string        // the newest line not yet sent
+	wake    chan struct{} // pokes writeLoop when there's something pending
+	done    chan struct{} // closed once the client is closed
+	closed  bool
+}
+
+// make line the next line to send, replacing anything not yet sent.
+func (c *client) offer(line string) {
+	c.mu.Lock()
+	c.pending = line
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.conn.Close()
+}
+
+func (c *client) writeLoop() {
+	defer c.server.removeClient(c)
+	defer c.close()
+	writer := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case <-c.wake:
+		case <-c.done:
+			return
+		}
+		c.mu.Lock()
+		line := c.pending
+		c.pending = ""
+		c.mu.Unlock()
+		if line == "" {
+			continue
+		}
+		writer.WriteString(line)
+		writer.WriteString("\r\n")
+		if err := writer.Flush(); err != nil {
+			return
+		}
+		if c.interval > 0 {
+			// anything published meanwhile waits in pending.
+			timer := time.NewTimer(c.interval)
+			select {
+			case <-timer.C:
+			case <-c.done:
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// boost clients don't send anything we need - read only to notice them
+// hanging up.
+func (c *client) readLoop() {
+	io.Copy(io.Discard, c.conn)
+	c.close()
+}
@@ -0,0 +1,178 @@
+package boostserver
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/psxtest"
+	"github.com/kuroneko/psx.go/vars"
+)
+
+// a boost client recording everything it receives.
+type testClient struct {
+	mu      sync.Mutex
+	updates []psx.BoostData
+}
+
+func (tc *testClient) count() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return len(tc.updates)
+}
+
+func (tc *testClient) last() psx.BoostData {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.updates[len(tc.updates)-1]
+}
+
+func startServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go s.Serve(context.Background(), l)
+	t.Cleanup(s.Close)
+	return l.Addr().String()
+}
+
+func startClient(t *testing.T, s *Server, addr string) *testClient {
+	tc := new(testClient)
+	bconn, _ := psx.NewBoostConnection(addr)
+	bconn.OnBoost = func(_ *psx.BoostConnection, data psx.BoostData) {
+		tc.mu.Lock()
+		tc.updates = append(tc.updates, data)
+		tc.mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	clients := s.Clients()
+	go bconn.Run(ctx)
+	for i := 0; s.Clients() == clients; i++ {
+		if i > 500 {
+			t.Fatal("Client never connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return tc
+}
+
+// wait for cond, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; !cond(); i++ {
+		if i > 500 {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	s := New()
+	addr := startServer(t, s)
+	s.Publish(psx.BoostData{Altitude: 100, Heading: 1})
+
+	// late joiners start with the latest update.
+	first := startClient(t, s, addr)
+	waitFor(t, "the latest update", func() bool { return first.count() == 1 })
+	second := startClient(t, s, addr)
+
+	s.Publish(psx.BoostData{OnGround: true, Heading: 2})
+	waitFor(t, "the update on both clients", func() bool {
+		return first.count() == 2 && second.count() == 2
+	})
+	if got := second.last(); !got.OnGround || got.Heading != 2 {
+		t.Errorf("Client received %+v", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := New()
+	s.MinInterval = 200 * time.Millisecond
+	addr := startServer(t, s)
+	tc := startClient(t, s, addr)
+
+	for i := 1; i <= 50; i++ {
+		s.Publish(psx.BoostData{Heading: float64(i)})
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := tc.count(); n > 1 {
+		t.Errorf("Client received %d updates within the rate limit", n)
+	}
+	// the newest update always gets through eventually.
+	waitFor(t, "the newest update", func() bool {
+		return tc.count() > 0 && tc.last().Heading == 50
+	})
+	if n := tc.count(); n > 2 {
+		t.Errorf("Client received %d updates, expected at most 2", n)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	// a short line, without elevation, in the upstream's own number format.
+	const upstreamLine = "G120.50;090.0;0;-0.0;51.4700;-0.45"
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	defer upstream.Close()
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte(upstreamLine + "\r\n"))
+		time.Sleep(time.Second)
+	}()
+
+	s := New()
+	addr := startServer(t, s)
+	tc := startClient(t, s, addr)
+
+	bconn, _ := psx.NewBoostConnection(upstream.Addr().String())
+	s.RelayFrom(bconn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bconn.Run(ctx)
+
+	waitFor(t, "a relayed update", func() bool { return tc.count() > 0 })
+	if got := tc.last().Raw; got != upstreamLine {
+		t.Errorf("Relayed %q, expected %q", got, upstreamLine)
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0",
+		psx.MessageDef{MessageType: psx.MsgTypeS, MessageMode: psx.MsgModeEcon, Index: 121, HumanName: vars.PositionVar})
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+
+	s := New()
+	addr := startServer(t, s)
+	tc := startClient(t, s, addr)
+
+	pconn, _ := psx.NewConnection(srv.Addr(), "boost")
+	s.SynthesizeFrom(pconn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pconn.Run(ctx)
+	for pconn.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.Set(vars.PositionVar, "0;0;3.141592653589793;10000000;250000;0.5;-0.5")
+	waitFor(t, "a synthesised update", func() bool { return tc.count() > 0 })
+	got := tc.last()
+	if got.Altitude != 10000 || math.Abs(got.Heading-180) > 1e-9 || math.Abs(got.Latitude-28.64789) > 1e-5 {
+		t.Errorf("Synthesised %+v", got)
+	}
+}
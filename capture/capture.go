@@ -0,0 +1,249 @@
+// Records the raw traffic of psx Connections to capture files, and reads
+// them back, so misbehaviour seen mid-flight can be attached to bug reports
+// and replayed later.
+//
+// Captures come in two formats: a compact binary format, and a plain text
+// format that can be read (or written) by hand.  Both hold a sequence of
+// records giving the time each line was seen, relative to the start of the
+// recording, the connection it was seen on, which way it travelled and the
+// line itself.
+//
+// The text format starts with a header line giving the wall clock start
+// time, followed by one line per record:
+//
+//	# psxcapture 2026-10-16T09:30:00.123456789Z
+//	0.000152 1 < id=3
+//	0.000310 1 > name=myAddon
+//
+// where < marks lines received from the server and > lines sent to it.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	// The capture file isn't in either capture format.
+	UnknownFormatError = errors.New("Not a capture file")
+	// A record in the capture couldn't be decoded.
+	CorruptCaptureError = errors.New("Capture file is corrupt")
+)
+
+// Format selects the capture file format.
+type Format int
+
+const (
+	FormatBinary Format = iota // compact binary format
+	FormatText                 // plain text, one record per line
+)
+
+// Record is a single captured line.
+type Record struct {
+	Time time.Duration     // when the line was seen, since the recording started
+	Conn uint32            // the connection the line travelled on, numbered from 1
+	Dir  psx.WireDirection // which way the line travelled
+	Line string            // the raw line, without its line ending
+}
+
+const (
+	binaryMagic = "PSXCAP1\n"
+	textMagic   = "# psxcapture "
+)
+
+// encodes records in one of the capture formats.
+type encoder struct {
+	format Format
+	w      *bufio.Writer
+	last   time.Duration // time of the last binary record, for deltas
+	buf    []byte
+}
+
+func newEncoder(w io.Writer, format Format) *encoder {
+	return &encoder{format: format, w: bufio.NewWriter(w), buf: make([]byte, 0, 3*binary.MaxVarintLen64)}
+}
+
+// write the file header for a recording started at start, returning the
+// number of bytes written.
+func (enc *encoder) header(start time.Time) (int, error) {
+	var n int
+	if enc.format == FormatText {
+		n, _ = fmt.Fprintf(enc.w, "%s%s\n", textMagic, start.UTC().Format(time.RFC3339Nano))
+	} else {
+		n, _ = enc.w.WriteString(binaryMagic)
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(start.UnixNano()))
+		enc.w.Write(ts[:])
+		n += len(ts)
+	}
+	enc.last = 0
+	return n, enc.w.Flush()
+}
+
+// write a record, returning the number of bytes written.
+func (enc *encoder) record(rec Record) (int, error) {
+	var n int
+	if enc.format == FormatText {
+		dir := "<"
+		if rec.Dir == psx.WireSent {
+			dir = ">"
+		}
+		n, _ = fmt.Fprintf(enc.w, "%d.%06d %d %s %s\n",
+			rec.Time/time.Second, (rec.Time%time.Second)/time.Microsecond, rec.Conn, dir, rec.Line)
+	} else {
+		delta := rec.Time - enc.last
+		if delta < 0 {
+			delta = 0
+		}
+		enc.last += delta
+		connDir := uint64(rec.Conn) << 1
+		if rec.Dir == psx.WireSent {
+			connDir |= 1
+		}
+		buf := binary.AppendUvarint(enc.buf[:0], uint64(delta))
+		buf = binary.AppendUvarint(buf, connDir)
+		buf = binary.AppendUvarint(buf, uint64(len(rec.Line)))
+		n, _ = enc.w.Write(buf)
+		m, _ := enc.w.WriteString(rec.Line)
+		n += m
+	}
+	// flush every record - a capture is most useful when things crash.
+	return n, enc.w.Flush()
+}
+
+// Reader reads records from a capture file in either format.
+type Reader struct {
+	format Format
+	r      *bufio.Reader
+	start  time.Time
+	last   time.Duration
+	lineNo int
+}
+
+// Start reading a capture, detecting its format from the header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	magic, err := reader.r.Peek(len(binaryMagic))
+	if err != nil && len(magic) == 0 {
+		return nil, UnknownFormatError
+	}
+	switch {
+	case bytes.Equal(magic, []byte(binaryMagic)):
+		reader.format = FormatBinary
+		var hdr [len(binaryMagic) + 8]byte
+		if _, err := io.ReadFull(reader.r, hdr[:]); err != nil {
+			return nil, CorruptCaptureError
+		}
+		reader.start = time.Unix(0, int64(binary.BigEndian.Uint64(hdr[len(binaryMagic):])))
+	case bytes.HasPrefix([]byte(textMagic), magic):
+		reader.format = FormatText
+		line, err := reader.r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, textMagic) {
+			return nil, UnknownFormatError
+		}
+		reader.lineNo++
+		reader.start, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(line[len(textMagic):]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad start time", CorruptCaptureError)
+		}
+	default:
+		return nil, UnknownFormatError
+	}
+	return reader, nil
+}
+
+// Returns the capture's format.
+func (reader *Reader) Format() Format {
+	return reader.format
+}
+
+// Returns the wall clock time the recording started.
+func (reader *Reader) Start() time.Time {
+	return reader.start
+}
+
+// Returns the next record, or io.EOF at the end of the capture.
+func (reader *Reader) Next() (rec Record, err error) {
+	if reader.format == FormatText {
+		return reader.nextText()
+	}
+	delta, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		if err == io.EOF {
+			return rec, io.EOF
+		}
+		return rec, CorruptCaptureError
+	}
+	connDir, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		return rec, CorruptCaptureError
+	}
+	length, err := binary.ReadUvarint(reader.r)
+	if err != nil || length > 1<<24 {
+		return rec, CorruptCaptureError
+	}
+	line := make([]byte, length)
+	if _, err := io.ReadFull(reader.r, line); err != nil {
+		return rec, CorruptCaptureError
+	}
+	reader.last += time.Duration(delta)
+	rec.Time = reader.last
+	rec.Conn = uint32(connDir >> 1)
+	if connDir&1 != 0 {
+		rec.Dir = psx.WireSent
+	}
+	rec.Line = string(line)
+	return rec, nil
+}
+
+// decode the next text format record.
+func (reader *Reader) nextText() (rec Record, err error) {
+	for {
+		line, err := reader.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return rec, err
+		}
+		reader.lineNo++
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// time, connection, direction and the line itself, which may
+		// contain spaces.
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 {
+			return rec, fmt.Errorf("%w: line %d", CorruptCaptureError, reader.lineNo)
+		}
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return rec, fmt.Errorf("%w: line %d: bad time", CorruptCaptureError, reader.lineNo)
+		}
+		rec.Time = time.Duration(seconds*1e6+0.5) * time.Microsecond
+		conn, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return rec, fmt.Errorf("%w: line %d: bad connection", CorruptCaptureError, reader.lineNo)
+		}
+		rec.Conn = uint32(conn)
+		switch fields[2] {
+		case "<":
+			rec.Dir = psx.WireReceived
+		case ">":
+			rec.Dir = psx.WireSent
+		default:
+			return rec, fmt.Errorf("%w: line %d: bad direction", CorruptCaptureError, reader.lineNo)
+		}
+		if len(fields) == 4 {
+			rec.Line = fields[3]
+		}
+		return rec, nil
+	}
+}
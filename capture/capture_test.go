@@ -0,0 +1,246 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/psxtest"
+)
+
+var testRecords = []Record{
+	{Time: 152 * time.Microsecond, Conn: 1, Dir: psx.WireReceived, Line: "id=3"},
+	{Time: 310 * time.Microsecond, Conn: 1, Dir: psx.WireSent, Line: "name=my addon"},
+	{Time: 2*time.Second + 5*time.Microsecond, Conn: 2, Dir: psx.WireReceived, Line: "Qi242=5"},
+	{Time: 3 * time.Second, Conn: 300, Dir: psx.WireSent, Line: ""},
+}
+
+func TestFormats(t *testing.T) {
+	start := time.Date(2026, 10, 16, 9, 30, 0, 123456789, time.UTC)
+	for _, format := range []Format{FormatBinary, FormatText} {
+		var buf bytes.Buffer
+		enc := newEncoder(&buf, format)
+		enc.header(start)
+		for _, rec := range testRecords {
+			enc.record(rec)
+		}
+
+		reader, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("Format %d: couldn't read header: %s", format, err)
+		}
+		if reader.Format() != format || !reader.Start().Equal(start) {
+			t.Errorf("Format %d: read back as format %d, started %s", format, reader.Format(), reader.Start())
+		}
+		for i, want := range testRecords {
+			got, err := reader.Next()
+			if err != nil {
+				t.Fatalf("Format %d: record %d: %s", format, i, err)
+			}
+			if got != want {
+				t.Errorf("Format %d: record %d is %+v, expected %+v", format, i, got, want)
+			}
+		}
+		if _, err := reader.Next(); err != io.EOF {
+			t.Errorf("Format %d: expected EOF, got %v", format, err)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewBufferString("Qi242=5\n")); err != UnknownFormatError {
+		t.Errorf("Non-capture read with error %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0")
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	rec, err := NewRecorder(path, RecorderOptions{Format: FormatText})
+	if err != nil {
+		t.Fatalf("Couldn't create recorder: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pconn, _ := psx.NewConnection(srv.Addr(), "recorded")
+	rec.Attach(pconn)
+	done := make(chan error)
+	go func() {
+		done <- pconn.Run(ctx)
+	}()
+	client, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never connected: %s", err)
+	}
+	if _, err := client.WaitFor("name", 5*time.Second); err != nil {
+		t.Fatalf("Client never sent name: %s", err)
+	}
+	cancel()
+	<-done
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Recording failed: %s", err)
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	reader, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("Couldn't read capture: %s", err)
+	}
+	var first, name *Record
+	var last time.Duration
+	for {
+		r, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if r.Time < last {
+			t.Errorf("Time went backwards at %+v", r)
+		}
+		last = r.Time
+		if first == nil {
+			first = &r
+		}
+		if r.Line == "name=recorded" {
+			name = &r
+		}
+	}
+	if first == nil || first.Dir != psx.WireReceived || first.Line != "id=1" || first.Conn != 1 {
+		t.Errorf("Unexpected first record %+v", first)
+	}
+	if name == nil || name.Dir != psx.WireSent {
+		t.Errorf("Sent name wasn't recorded correctly: %+v", name)
+	}
+}
+
+func TestRecorderAttachRunning(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0")
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	rec, err := NewRecorder(path, RecorderOptions{Format: FormatText})
+	if err != nil {
+		t.Fatalf("Couldn't create recorder: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pconn, _ := psx.NewConnection(srv.Addr(), "recorded")
+	done := make(chan error)
+	go func() {
+		done <- pconn.Run(ctx)
+	}()
+	client, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never connected: %s", err)
+	}
+	if _, err := client.WaitFor("name", 5*time.Second); err != nil {
+		t.Fatalf("Client never sent name: %s", err)
+	}
+
+	// attaching whilst the listener is running must be safe.
+	rec.Attach(pconn)
+	pconn.SendMsg(pconn.NewPair("Qi1", "1"))
+	if _, err := client.WaitFor("Qi1", 5*time.Second); err != nil {
+		t.Fatalf("Client never sent update: %s", err)
+	}
+	cancel()
+	<-done
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Recording failed: %s", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "Qi1=1") {
+		t.Errorf("Update sent after Attach wasn't recorded: %q", data)
+	}
+}
+
+func TestRecorderRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.bin")
+	rec, err := NewRecorder(path, RecorderOptions{MaxSize: 64, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("Couldn't create recorder: %s", err)
+	}
+	pconn, _ := psx.NewConnection("localhost:0", "test")
+	for i := 0; i < 50; i++ {
+		rec.Tap(pconn, 1, psx.WireReceived, "Qs121=0.1;0;3.14;35000000;250000")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Recording failed: %s", err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Errorf("Missing capture file: %s", err)
+			continue
+		}
+		if info.Size() > 64+50 {
+			t.Errorf("%s is %d bytes, over the cap", name, info.Size())
+		}
+		// every file must be readable on its own.
+		f, _ := os.Open(name)
+		if _, err := NewReader(f); err != nil {
+			t.Errorf("%s isn't a capture: %s", name, err)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Too many rotated files kept")
+	}
+}
+
+func TestRecorderRotationKeepAll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.bin")
+	rec, err := NewRecorder(path, RecorderOptions{MaxSize: 64})
+	if err != nil {
+		t.Fatalf("Couldn't create recorder: %s", err)
+	}
+	pconn, _ := psx.NewConnection("localhost:0", "test")
+	for i := 0; i < 50; i++ {
+		rec.Tap(pconn, 1, psx.WireReceived, "Qi242="+strconv.Itoa(i)+";0;3.14;35000000;250000")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Recording failed: %s", err)
+	}
+
+	// every record survives, oldest in the highest numbered file.
+	names := []string{path}
+	for i := 1; ; i++ {
+		name := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) < 3 {
+		t.Fatalf("Only %d files written - the capture didn't rotate", len(names))
+	}
+	next := 0
+	for i := len(names) - 1; i >= 0; i-- {
+		records, err := ReadFile(names[i])
+		if err != nil {
+			t.Fatalf("Couldn't read %s: %s", names[i], err)
+		}
+		for _, record := range records {
+			if want := "Qi242=" + strconv.Itoa(next) + ";"; !strings.HasPrefix(record.Line, want) {
+				t.Fatalf("%s has %q, expected %s...", names[i], record.Line, want)
+			}
+			next++
+		}
+	}
+	if next != 50 {
+		t.Errorf("Only %d of 50 records kept", next)
+	}
+}
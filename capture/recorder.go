@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+// RecorderOptions control the format and size of a Recorder's files.
+type RecorderOptions struct {
+	Format Format
+	// Once the capture file reaches MaxSize bytes it is rotated.  Zero
+	// never rotates.
+	MaxSize int64
+	// How many rotated files to keep, as path.1 (the newest) to
+	// path.MaxFiles.  Older files are deleted, so the capture never takes
+	// much more than MaxSize * (MaxFiles + 1) bytes.  Zero keeps every
+	// file.
+	MaxFiles int
+}
+
+// identifies a single TCP connection of a psx.Connection
+type connKey struct {
+	pconn  *psx.Connection
+	serial uint64
+}
+
+// Recorder writes the traffic of one or more psx Connections to a capture
+// file.
+type Recorder struct {
+	path  string
+	opts  RecorderOptions
+	start time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	enc      *encoder
+	size     int64
+	conns    map[connKey]uint32
+	lastConn uint32
+	err      error // the first write error - recording stops once set
+}
+
+// Create a recorder writing to path, replacing anything already there.
+func NewRecorder(path string, opts RecorderOptions) (rec *Recorder, err error) {
+	rec = &Recorder{
+		path:  path,
+		opts:  opts,
+		start: time.Now(),
+		conns: make(map[connKey]uint32),
+	}
+	if err = rec.open(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// start a fresh capture file.  Called with mu held (or before the recorder
+// is shared).
+func (rec *Recorder) open() (err error) {
+	rec.file, err = os.Create(rec.path)
+	if err != nil {
+		return err
+	}
+	rec.enc = newEncoder(rec.file, rec.opts.Format)
+	n, err := rec.enc.header(rec.start)
+	rec.size = int64(n)
+	return err
+}
+
+// move the current file aside and start a new one.  Called with mu held.
+func (rec *Recorder) rotate() error {
+	rec.file.Close()
+	keep := rec.opts.MaxFiles
+	if keep <= 0 {
+		// keep everything - make room for one more than there are.
+		keep = 1
+		for {
+			if _, err := os.Stat(rec.rotatedPath(keep)); err != nil {
+				break
+			}
+			keep++
+		}
+	} else {
+		os.Remove(rec.rotatedPath(keep))
+	}
+	for i := keep - 1; i >= 1; i-- {
+		os.Rename(rec.rotatedPath(i), rec.rotatedPath(i+1))
+	}
+	if err := os.Rename(rec.path, rec.rotatedPath(1)); err != nil {
+		return err
+	}
+	return rec.open()
+}
+
+// returns the name of the numbered rotated file.
+func (rec *Recorder) rotatedPath(n int) string {
+	return rec.path + "." + strconv.Itoa(n)
+}
+
+// Record the traffic of pconn.  This replaces any WireTap already set, and
+// is safe whilst pconn is running.
+func (rec *Recorder) Attach(pconn *psx.Connection) {
+	pconn.SetWireTap(rec.Tap)
+}
+
+// The WireTapHook that records each line - use Attach, or call it from your
+// own WireTap.
+func (rec *Recorder) Tap(pconn *psx.Connection, serial uint64, dir psx.WireDirection, line string) {
+	// take the time before queuing for the lock, so it's as close to when
+	// the line was seen as we can get.
+	seen := time.Since(rec.start)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil || rec.enc == nil {
+		return
+	}
+	key := connKey{pconn, serial}
+	conn, found := rec.conns[key]
+	if !found {
+		rec.lastConn++
+		conn = rec.lastConn
+		rec.conns[key] = conn
+	}
+	n, err := rec.enc.record(Record{Time: seen, Conn: conn, Dir: dir, Line: line})
+	rec.size += int64(n)
+	if err == nil && rec.opts.MaxSize > 0 && rec.size >= rec.opts.MaxSize {
+		err = rec.rotate()
+	}
+	rec.err = err
+}
+
+// Stop recording and close the capture file.  Returns the first error
+// encountered whilst recording, if any.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.enc == nil {
+		return rec.err
+	}
+	if err := rec.file.Close(); rec.err == nil {
+		rec.err = err
+	}
+	rec.enc = nil
+	return rec.err
+}
@@ -0,0 +1,373 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	// Returned by Serve once the Replayer has been closed.
+	ReplayerClosedError = errors.New("replay: closed")
+	// The capture has no server traffic for the requested connection.
+	NothingToReplayError = errors.New("Capture has nothing to replay")
+)
+
+// Records received closer together than this are sent as a single frame.
+const DefaultFrameGap = 5 * time.Millisecond
+
+// ReplayOptions control how a Replayer plays a capture back.
+type ReplayOptions struct {
+	// The capture connection to replay.  Zero replays the first connection
+	// in the capture.
+	Conn uint32
+	// Playback speed - 2 plays twice as fast as recorded.  Zero (or less)
+	// plays in real time.
+	Speed float64
+	// Send frames as fast as the client will take them, ignoring the
+	// recorded timing.
+	Unpaced bool
+	// Wait for a call to Step before sending each frame.
+	Stepped bool
+	// Records received less than FrameGap apart are sent together as one
+	// frame.  Zero uses DefaultFrameGap.
+	FrameGap time.Duration
+	// Start again from the first frame once the capture ends, rather than
+	// sending exit.
+	Loop bool
+}
+
+// a group of lines sent together during playback
+type frame struct {
+	at    time.Duration // when the first line was recorded
+	lines []string
+}
+
+// Replayer plays the server side of a capture back to any number of
+// clients, so a recorded session can be reproduced without a simulator.
+//
+// Every client gets its own playback, starting with the session handshake
+// (id, version, lexicon and load lines) which is sent immediately, then the
+// rest of the capture with its recorded timing, sped up or stepped as
+// configured.
+type Replayer struct {
+	opts      ReplayOptions
+	handshake []string
+	frames    []frame
+
+	mu        sync.Mutex
+	steps     int           // frames released by Step
+	stepped   chan struct{} // closed (and replaced) on each Step
+	nextId    int
+	listeners []net.Listener
+	conns     map[net.Conn]bool
+	closed    bool
+}
+
+// Read every record from the capture file at path.  Rotated capture files
+// can each be read on their own.
+func ReadFile(path string) (records []Record, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// returns true if line is part of the session handshake.
+func isHandshake(line string) bool {
+	key := line
+	if idx := strings.Index(line, "="); idx >= 0 {
+		key = line[:idx]
+	}
+	switch key {
+	case "id", "version", "load1", "load2", "load3":
+		return true
+	}
+	return strings.HasPrefix(key, "L")
+}
+
+// Create a Replayer for the lines received in records.
+func NewReplayer(records []Record, opts ReplayOptions) (*Replayer, error) {
+	if opts.FrameGap <= 0 {
+		opts.FrameGap = DefaultFrameGap
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	rp := &Replayer{
+		opts:    opts,
+		stepped: make(chan struct{}),
+		nextId:  1,
+		conns:   make(map[net.Conn]bool),
+	}
+
+	var received []Record
+	for _, rec := range records {
+		if rec.Dir != psx.WireReceived {
+			continue
+		}
+		if rp.opts.Conn == 0 {
+			rp.opts.Conn = rec.Conn
+		}
+		if rec.Conn == rp.opts.Conn {
+			received = append(received, rec)
+		}
+	}
+	if len(received) == 0 {
+		return nil, NothingToReplayError
+	}
+
+	// the handshake runs up to the first load3 - or, if the capture started
+	// mid-session, is whatever handshake lines it starts with.
+	handshakeLen := 0
+	for i, rec := range received {
+		if rec.Line == "load3" {
+			handshakeLen = i + 1
+			break
+		}
+	}
+	if handshakeLen == 0 {
+		for handshakeLen < len(received) && isHandshake(received[handshakeLen].Line) {
+			handshakeLen++
+		}
+	}
+	for _, rec := range received[:handshakeLen] {
+		rp.handshake = append(rp.handshake, rec.Line)
+	}
+
+	var last time.Duration
+	for _, rec := range received[handshakeLen:] {
+		if rec.Line == "exit" {
+			// playback sends its own exit once it's done.
+			continue
+		}
+		if len(rp.frames) == 0 || rec.Time-last >= opts.FrameGap {
+			rp.frames = append(rp.frames, frame{at: rec.Time})
+		}
+		f := &rp.frames[len(rp.frames)-1]
+		f.lines = append(f.lines, rec.Line)
+		last = rec.Time
+	}
+	return rp, nil
+}
+
+// Returns the number of frames after the handshake.
+func (rp *Replayer) Frames() int {
+	return len(rp.frames)
+}
+
+// Release the next frame to every client of a Stepped Replayer.  Each
+// client steps through its own playback, so one that connects later starts
+// from the first frame, at the next Step.
+func (rp *Replayer) Step() {
+	rp.mu.Lock()
+	rp.steps++
+	close(rp.stepped)
+	rp.stepped = make(chan struct{})
+	rp.mu.Unlock()
+}
+
+// wait until n frames have been released by Step.  Returns false if ctx
+// was cancelled first.
+func (rp *Replayer) waitStep(ctx context.Context, n int) bool {
+	for {
+		rp.mu.Lock()
+		steps, stepped := rp.steps, rp.stepped
+		rp.mu.Unlock()
+		if steps >= n {
+			return true
+		}
+		select {
+		case <-stepped:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Listen on addr and replay to clients until ctx is cancelled.
+func (rp *Replayer) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return rp.Serve(ctx, l)
+}
+
+// Accept clients on l and replay to them until ctx is cancelled or the
+// Replayer is closed.  l is closed when Serve returns.
+func (rp *Replayer) Serve(ctx context.Context, l net.Listener) error {
+	rp.mu.Lock()
+	if rp.closed {
+		rp.mu.Unlock()
+		l.Close()
+		return ReplayerClosedError
+	}
+	rp.listeners = append(rp.listeners, l)
+	rp.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			l.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			rp.mu.Lock()
+			closed := rp.closed
+			rp.mu.Unlock()
+			if closed {
+				return ReplayerClosedError
+			}
+			return err
+		}
+		rp.mu.Lock()
+		if rp.closed {
+			rp.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		id := rp.nextId
+		rp.nextId++
+		rp.conns[conn] = true
+		rp.mu.Unlock()
+		go rp.play(ctx, conn, id)
+	}
+}
+
+// Stop serving and disconnect all clients.
+func (rp *Replayer) Close() {
+	rp.mu.Lock()
+	rp.closed = true
+	listeners := rp.listeners
+	rp.listeners = nil
+	conns := rp.conns
+	rp.conns = make(map[net.Conn]bool)
+	rp.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+// play the capture back to a single client.
+func (rp *Replayer) play(ctx context.Context, conn net.Conn, id int) {
+	// steps taken before this client connected aren't for it.
+	rp.mu.Lock()
+	base := rp.steps
+	rp.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		conn.Close()
+		rp.mu.Lock()
+		delete(rp.conns, conn)
+		rp.mu.Unlock()
+	}()
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	// whatever the client sends is ignored, but we stop once it hangs up.
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	writer := bufio.NewWriter(conn)
+	send := func(lines []string) bool {
+		for _, line := range lines {
+			writer.WriteString(line)
+			writer.WriteString("\r\n")
+		}
+		return writer.Flush() == nil
+	}
+
+	handshake := make([]string, len(rp.handshake))
+	for i, line := range rp.handshake {
+		if strings.HasPrefix(line, "id=") {
+			// each client gets its own id, as it would from a real server.
+			line = "id=" + strconv.Itoa(id)
+		}
+		handshake[i] = line
+	}
+	if !send(handshake) {
+		return
+	}
+
+	sent := 0
+	for {
+		begin := time.Now()
+		for _, f := range rp.frames {
+			if rp.opts.Stepped {
+				if !rp.waitStep(ctx, base+sent+1) {
+					return
+				}
+			} else if !rp.opts.Unpaced {
+				offset := time.Duration(float64(f.at-rp.frames[0].at) / rp.opts.Speed)
+				timer := time.NewTimer(time.Until(begin.Add(offset)))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+			if !send(f.lines) {
+				return
+			}
+			sent++
+		}
+		if !rp.opts.Loop || len(rp.frames) == 0 {
+			break
+		}
+	}
+	send([]string{"exit"})
+	// give the client the chance to hang up first, so it sees the exit
+	// rather than a reset.
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
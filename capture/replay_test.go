@@ -0,0 +1,156 @@
+package capture
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+func testCapture() (records []Record) {
+	at := time.Duration(0)
+	add := func(dir psx.WireDirection, line string) {
+		records = append(records, Record{Time: at, Conn: 1, Dir: dir, Line: line})
+	}
+	for _, line := range []string{"id=7", "version=10.0.0", "Li242(Z)=UplinkBits", "load1", "load2", "load3"} {
+		add(psx.WireReceived, line)
+	}
+	add(psx.WireSent, "name=recorded")
+	for _, value := range []string{"1", "2", "3"} {
+		at += 100 * time.Millisecond
+		add(psx.WireReceived, "Qi242="+value)
+	}
+	add(psx.WireReceived, "exit")
+	return records
+}
+
+func startReplay(t *testing.T, opts ReplayOptions) (rp *Replayer, addr string) {
+	rp, err := NewReplayer(testCapture(), opts)
+	if err != nil {
+		t.Fatalf("Couldn't create replayer: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go rp.Serve(context.Background(), l)
+	t.Cleanup(rp.Close)
+	return rp, l.Addr().String()
+}
+
+func startReplayClient(addr string) (pconn *psx.Connection, values chan string, done chan error) {
+	pconn, _ = psx.NewConnection(addr, "replayed")
+	values = make(chan string, 10)
+	pconn.Hooks["UplinkBits"] = func(_ *psx.Connection, msg *psx.WireMsg) {
+		values <- msg.Value
+	}
+	done = make(chan error, 1)
+	go func() {
+		done <- pconn.Run(context.Background())
+	}()
+	return pconn, values, done
+}
+
+func TestReplay(t *testing.T) {
+	rp, addr := startReplay(t, ReplayOptions{Speed: 10})
+	if rp.Frames() != 3 {
+		t.Errorf("Capture split into %d frames, expected 3", rp.Frames())
+	}
+
+	start := time.Now()
+	pconn, values, done := startReplayClient(addr)
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case got := <-values:
+			if got != want {
+				t.Errorf("Got %q, expected %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+	// 200ms of capture at 10x.
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Replay took %s - timing wasn't honoured", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Replay didn't end cleanly: %s", err)
+	}
+	if pconn.Id() != 1 || pconn.Version() != "10.0.0" {
+		t.Errorf("Unexpected handshake: id %d version %q", pconn.Id(), pconn.Version())
+	}
+}
+
+func TestReplayStepped(t *testing.T) {
+	rp, addr := startReplay(t, ReplayOptions{Stepped: true})
+	pconn, values, _ := startReplayClient(addr)
+	for pconn.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case got := <-values:
+		t.Fatalf("Got %q before stepping", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	for _, want := range []string{"1", "2"} {
+		rp.Step()
+		select {
+		case got := <-values:
+			if got != want {
+				t.Errorf("Got %q, expected %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+	select {
+	case got := <-values:
+		t.Errorf("Got %q without a step", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReplaySteppedLateJoiner(t *testing.T) {
+	rp, addr := startReplay(t, ReplayOptions{Stepped: true})
+	expect := func(values chan string, want string) {
+		t.Helper()
+		select {
+		case got := <-values:
+			if got != want {
+				t.Errorf("Got %q, expected %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+	expectNothing := func(values chan string) {
+		t.Helper()
+		select {
+		case got := <-values:
+			t.Errorf("Got %q without a step", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	first, firstValues, _ := startReplayClient(addr)
+	for first.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rp.Step()
+	expect(firstValues, "1")
+	rp.Step()
+	expect(firstValues, "2")
+
+	// the second client mustn't burst through the steps it missed.
+	second, secondValues, _ := startReplayClient(addr)
+	for second.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectNothing(secondValues)
+	rp.Step()
+	expect(firstValues, "3")
+	expect(secondValues, "1")
+	expectNothing(secondValues)
+}
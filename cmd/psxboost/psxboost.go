@@ -0,0 +1,69 @@
+// psxboost.go
+//
+// A boost protocol server.  Either relays an upstream boost server to any
+// number of local clients, or synthesises boost updates from the PSX main
+// server's PiBaHeAlTas variable.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/boostserver"
+	"github.com/kuroneko/psx.go/vars"
+)
+
+var (
+	boostAddr  = flag.String("boost", "", "upstream boost server to relay (eg: localhost:10749)")
+	mainAddr   = flag.String("main", "", "PSX main server to synthesise updates from (eg: localhost:10747)")
+	listenAddr = flag.String("listen", ":10749", "address to accept clients on")
+	interval   = flag.Duration("interval", 0, "minimum time between updates to each client")
+)
+
+func main() {
+	flag.Parse()
+	if (*boostAddr == "") == (*mainAddr == "") {
+		fmt.Fprintln(os.Stderr, "psxboost: give exactly one of -boost or -main")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	srv := boostserver.New()
+	srv.MinInterval = *interval
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *boostAddr != "" {
+		bconn, _ := psx.NewBoostConnection(*boostAddr)
+		bconn.OnReconnect = func(_ *psx.BoostConnection, attempt int, delay time.Duration, err error) {
+			fmt.Printf("Upstream boost connection lost (%v), reconnecting in %s (attempt %d)\n", err, delay, attempt)
+		}
+		srv.RelayFrom(bconn)
+		go bconn.RunWithReconnect(ctx)
+		fmt.Printf("Relaying boost from %s to clients on %s\n", *boostAddr, *listenAddr)
+	} else {
+		pconn, _ := psx.NewConnection(*mainAddr, "psxboost")
+		pconn.Subscribe(vars.PositionVar)
+		pconn.OnReconnect = func(_ *psx.Connection, attempt int, delay time.Duration, err error) {
+			fmt.Printf("Main server connection lost (%v), reconnecting in %s (attempt %d)\n", err, delay, attempt)
+		}
+		srv.SynthesizeFrom(pconn)
+		go pconn.RunWithReconnect(ctx)
+		fmt.Printf("Synthesising boost from %s for clients on %s\n", *mainAddr, *listenAddr)
+	}
+
+	err := srv.ListenAndServe(ctx, *listenAddr)
+	srv.Close()
+	if err != nil && err != context.Canceled {
+		fmt.Printf("Boost server failed: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,203 @@
+// psxgen.go
+//
+// Generate Go constants for the lexicon's variable names, along with typed
+// getters (reading from a psx.Store) and setters (sending on a
+// psx.Connection), so misspelt names are caught by the compiler.
+//
+// Usage:
+//
+//	psxgen -lexicon lexicon.txt -package psxvars -o psxvars.go
+//	psxgen -variables Variables.txt -package psxvars -o psxvars.go
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	lexiconPath   = flag.String("lexicon", "", "lexicon cache file (as saved by SaveLexicon) to read")
+	variablesPath = flag.String("variables", "", "PSX Variables.txt file to read")
+	packageName   = flag.String("package", "psxvars", "package name for the generated code")
+	prefix        = flag.String("prefix", "", "prefix for the generated identifiers")
+	outputPath    = flag.String("o", "", "file to write (default stdout)")
+)
+
+// a variable as presented to the template
+type genVar struct {
+	Ident string // Go identifier for the variable
+	Name  string // the human name
+	Key   string // the wire key
+	Mode  string // the mode letter, as used in lexicon lines
+	Type  string // Go type of the value
+	Int   bool   // the value is decoded as an integer
+}
+
+var genTemplate = template.Must(template.New("psxgen").Parse(`// Code generated by psxgen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import "github.com/kuroneko/psx.go"
+
+// Variable names.
+const (
+{{- range .Vars}}
+	{{.Ident}} = {{printf "%q" .Name}} // {{.Key}} ({{.Mode}})
+{{- end}}
+)
+{{range .Vars}}
+// Returns the last {{.Name}} value in store.
+func Get{{.Ident}}(store *psx.Store) ({{.Type}}, error) {
+	entry, found := store.Get({{.Ident}})
+	if !found {
+{{- if .Int}}
+		return 0, psx.NoValueError
+	}
+	return entry.Msg.Int()
+{{- else}}
+		return "", psx.NoValueError
+	}
+	return entry.Msg.Value, nil
+{{- end}}
+}
+
+// Sends a new {{.Name}} value on pconn.
+func Set{{.Ident}}(pconn *psx.Connection, val {{.Type}}) error {
+	msg := pconn.NewPair({{.Ident}}, "")
+{{- if .Int}}
+	msg.SetInt(val)
+{{- else}}
+	msg.SetString(val)
+{{- end}}
+	return pconn.SendMsg(msg)
+}
+{{end}}`))
+
+// turn a human name into an exported Go identifier.
+func goIdent(name string) string {
+	var ident strings.Builder
+	ident.WriteString(*prefix)
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			ident.WriteRune(r)
+		} else {
+			ident.WriteRune('_')
+		}
+	}
+	runes := []rune(ident.String())
+	if len(runes) == 0 || !unicode.IsLetter(runes[0]) {
+		return "V" + string(runes)
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// returns ident, or a variation on it, such that neither it nor its getter
+// and setter collide with anything in used, and reserves all three.
+func uniqueIdent(used map[string]bool, ident, key string) string {
+	taken := func(ident string) bool {
+		return used[ident] || used["Get"+ident] || used["Set"+ident]
+	}
+	// names that only differ in punctuation would collide, as would a name
+	// that looks like another's getter or setter.
+	if taken(ident) {
+		ident += "_" + key
+	}
+	for base, n := ident, 2; taken(ident); n++ {
+		ident = fmt.Sprintf("%s_%d", base, n)
+	}
+	used[ident] = true
+	used["Get"+ident] = true
+	used["Set"+ident] = true
+	return ident
+}
+
+// generate the Go source for the lexicon.
+func generate(w io.Writer, lex *psx.Lexicon, source string) error {
+	var vars []genVar
+	used := make(map[string]bool)
+	for _, def := range lex.Definitions() {
+		v := genVar{
+			Name: def.HumanName,
+			Key:  def.KeyString(),
+			Mode: def.ModeString(),
+			Type: "string",
+		}
+		if v.Mode == "" {
+			v.Mode = "?"
+		}
+		if def.MessageType != psx.MsgTypeS {
+			v.Type = "int"
+			v.Int = true
+		}
+		v.Ident = uniqueIdent(used, goIdent(def.HumanName), v.Key)
+		vars = append(vars, v)
+	}
+
+	var buf bytes.Buffer
+	err := genTemplate.Execute(&buf, map[string]interface{}{
+		"Source":  source,
+		"Package": *packageName,
+		"Vars":    vars,
+	})
+	if err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated invalid code: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func main() {
+	flag.Parse()
+
+	var lex *psx.Lexicon
+	var source string
+	var err error
+	switch {
+	case *lexiconPath != "" && *variablesPath == "":
+		var version string
+		lex, version, err = psx.LoadLexicon(*lexiconPath)
+		source = fmt.Sprintf("%s (version %s)", *lexiconPath, version)
+	case *variablesPath != "" && *lexiconPath == "":
+		lex, err = psx.LoadVariables(*variablesPath)
+		source = *variablesPath
+	default:
+		fmt.Fprintln(os.Stderr, "psxgen: give exactly one of -lexicon or -variables")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "psxgen: %s\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if *outputPath != "" {
+		if out, err = os.Create(*outputPath); err != nil {
+			fmt.Fprintf(os.Stderr, "psxgen: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	err = generate(out, lex, source)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "psxgen: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/kuroneko/psx.go"
+)
+
+func TestGoIdent(t *testing.T) {
+	for name, want := range map[string]string{
+		"PiBaHeAlTas": "PiBaHeAlTas",
+		"lightsTaxi":  "LightsTaxi",
+		"Key-Name":    "Key_Name",
+		"3dThing":     "V3dThing",
+	} {
+		if got := goIdent(name); got != want {
+			t.Errorf("goIdent(%q) = %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	lex := psx.NewLexicon(
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+		psx.MessageDef{MessageType: psx.MsgTypeS, MessageMode: psx.MsgModeEcon, Index: 121, HumanName: "PiBaHeAlTas"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 5, HumanName: "Key-Name"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 6, HumanName: "Key_Name"},
+	)
+	var buf bytes.Buffer
+	if err := generate(&buf, lex, "test"); err != nil {
+		t.Fatalf("Generate failed: %s", err)
+	}
+	src := buf.String()
+	for _, want := range []string{
+		`"UplinkBits"  // Qi242 (Z)`,
+		"func GetUplinkBits(store *psx.Store) (int, error)",
+		"func SetPiBaHeAlTas(pconn *psx.Connection, val string) error",
+		"func GetKey_Name_Qi6(",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("Generated code is missing %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateCollisions(t *testing.T) {
+	lex := psx.NewLexicon(
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 1, HumanName: "Foo"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 2, HumanName: "GetFoo"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 3, HumanName: "Bar"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 4, HumanName: "Bar-Qi3"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 5, HumanName: "Bar.Qi3"},
+		// not a mode PSX uses, so it has no letter.
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: 99, Index: 6, HumanName: "Odd"},
+		// "3" becomes V3, which is taken, as is the key suffixed version.
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 7, HumanName: "V3"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 8, HumanName: "V3_Qi9"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeEcon, Index: 9, HumanName: "3"},
+	)
+	var buf bytes.Buffer
+	if err := generate(&buf, lex, "test"); err != nil {
+		t.Fatalf("Generate failed: %s", err)
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "gen.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("Generated code doesn't parse: %s", err)
+	}
+	declared := make(map[string]bool)
+	for name, obj := range file.Scope.Objects {
+		if obj.Kind == ast.Con || obj.Kind == ast.Fun {
+			declared[name] = true
+		}
+	}
+	// the parser only keeps one declaration of each name, so a
+	// collision shows up as a missing declaration.
+	if len(declared) != 3*9 {
+		t.Errorf("Generated %d declarations, expected %d:\n%s", len(declared), 3*9, buf.String())
+	}
+	if !strings.Contains(buf.String(), "// Qi6 (?)") {
+		t.Errorf("Unknown mode wasn't marked:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,71 @@
+// psxreplay.go
+//
+// Replay a capture file recorded with the capture package, standing in for
+// the PSX main server.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kuroneko/psx.go/capture"
+)
+
+var (
+	listenAddr = flag.String("listen", ":10747", "address to accept clients on")
+	speed      = flag.Float64("speed", 1.0, "playback speed multiplier")
+	connId     = flag.Uint("conn", 0, "capture connection to replay (default the first)")
+	loop       = flag.Bool("loop", false, "loop the capture rather than ending")
+	step       = flag.Bool("step", false, "send one frame each time enter is pressed")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] capture-file\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	records, err := capture.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Printf("Couldn't read capture: %s\n", err)
+		os.Exit(1)
+	}
+	rp, err := capture.NewReplayer(records, capture.ReplayOptions{
+		Conn:    uint32(*connId),
+		Speed:   *speed,
+		Stepped: *step,
+		Loop:    *loop,
+	})
+	if err != nil {
+		fmt.Printf("Couldn't replay capture: %s\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *step {
+		go func() {
+			stdin := bufio.NewScanner(os.Stdin)
+			for stdin.Scan() {
+				rp.Step()
+			}
+		}()
+	}
+
+	fmt.Printf("Replaying %d frames from %s on %s\n", rp.Frames(), flag.Arg(0), *listenAddr)
+	err = rp.ListenAndServe(ctx, *listenAddr)
+	rp.Close()
+	if err != nil && err != context.Canceled {
+		fmt.Printf("Replay failed: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+// psxrouter.go
+//
+// A Router/SwitchPSX compatible multiplexer.  Connects to the simulator once
+// and shares that connection with any number of local clients.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/router"
+)
+
+var (
+	upstreamAddr = flag.String("upstream", "localhost:10747", "address of the PSX main server")
+	listenAddr   = flag.String("listen", ":10748", "address to accept clients on")
+	clientName   = flag.String("name", "psxrouter", "name to report to the upstream server")
+)
+
+func reportReconnect(_ *psx.Connection, attempt int, delay time.Duration, err error) {
+	fmt.Printf("Upstream connection lost (%v), reconnecting in %s (attempt %d)\n", err, delay, attempt)
+}
+
+func reportState(oldState, newState psx.ConnState) {
+	fmt.Printf("Upstream: %s -> %s\n", oldState, newState)
+}
+
+func reportClientError(info router.ClientInfo, err error) {
+	fmt.Printf("Client %d (%s): %s\n", info.Id, info.Name, err)
+}
+
+func main() {
+	flag.Parse()
+
+	upstream, err := psx.NewConnection(*upstreamAddr, *clientName)
+	if err != nil {
+		fmt.Printf("Couldn't initialise connection: %s\n", err)
+		os.Exit(1)
+	}
+	upstream.OnReconnect = reportReconnect
+	upstream.OnStateChange(reportState)
+
+	rtr := router.New(upstream)
+	rtr.OnClientError = reportClientError
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go upstream.RunWithReconnect(ctx)
+
+	fmt.Printf("Routing %s to clients on %s\n", *upstreamAddr, *listenAddr)
+	err = rtr.ListenAndServe(ctx, *listenAddr)
+	rtr.Close()
+	if err != nil && err != context.Canceled {
+		fmt.Printf("Router failed: %s\n", err)
+		os.Exit(1)
+	}
+}
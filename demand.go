@@ -0,0 +1,97 @@
+package psx
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	// Returned by Demand when the lexicon says the variable isn't a demand
+	// mode (N) variable.
+	NotDemandModeError = errors.New("Variable is not a demand mode variable")
+)
+
+// the server keys used to request and withdraw demand mode variables
+const (
+	demandKey   = "demand"
+	noDemandKey = "nodemand"
+)
+
+// Register interest in the named demand mode (N) variable.
+//
+// The server only sends demand mode variables to clients that ask for them.
+// The request is sent as soon as the lexicon has been learned, and repeated
+// on every new connection.  Demands are counted, so independent parts of an
+// addon can each Demand and Undemand the same variable.
+func (pconn *Connection) Demand(humanKey string) error {
+	if def := pconn.lex.lookupName(humanKey); def != nil && def.MessageMode != MsgModeDemand {
+		return NotDemandModeError
+	}
+	pconn.mu.Lock()
+	if pconn.demands == nil {
+		pconn.demands = make(map[string]int)
+	}
+	pconn.demands[humanKey]++
+	first := pconn.demands[humanKey] == 1
+	pconn.mu.Unlock()
+
+	if first && pconn.lexiconReady() {
+		return pconn.sendDemand(demandKey, humanKey)
+	}
+	return nil
+}
+
+// Withdraw interest in the named demand mode variable.
+//
+// Once every Demand for the variable has been matched by an Undemand, the
+// server is told we no longer need it.
+func (pconn *Connection) Undemand(humanKey string) error {
+	pconn.mu.Lock()
+	count, found := pconn.demands[humanKey]
+	if !found {
+		pconn.mu.Unlock()
+		return nil
+	}
+	if count > 1 {
+		pconn.demands[humanKey] = count - 1
+		pconn.mu.Unlock()
+		return nil
+	}
+	delete(pconn.demands, humanKey)
+	pconn.mu.Unlock()
+
+	if pconn.lexiconReady() {
+		return pconn.sendDemand(noDemandKey, humanKey)
+	}
+	return nil
+}
+
+// Returns the names of the demand mode variables currently demanded, sorted.
+func (pconn *Connection) Demands() []string {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	names := make([]string, 0, len(pconn.demands))
+	for name := range pconn.demands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// send a demand or nodemand for the named variable.  Names the lexicon
+// doesn't know are ignored, as they can't be encoded.
+func (pconn *Connection) sendDemand(action, humanKey string) error {
+	keyName := pconn.lex.keyFor(humanKey)
+	if keyName == "" {
+		return nil
+	}
+	return pconn.SendMsg(pconn.NewPair(action, keyName))
+}
+
+// send all of our outstanding demands - used once a new connection has
+// learned the lexicon.
+func (pconn *Connection) sendDemands() {
+	for _, humanKey := range pconn.Demands() {
+		pconn.sendDemand(demandKey, humanKey)
+	}
+}
@@ -0,0 +1,64 @@
+package psx
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemand(t *testing.T) {
+	demands := make(chan string, 10)
+	addr := startFakeServer(t, func(c net.Conn) {
+		c.Write([]byte("id=1\r\nLs440(N)=TcasData\r\nLi242(Z)=UplinkBits\r\nload1\r\nload2\r\nload3\r\n"))
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), "demand=") {
+				demands <- scanner.Text()
+			}
+		}
+	})
+	expectDemand := func(want string) {
+		t.Helper()
+		select {
+		case got := <-demands:
+			if got != want {
+				t.Errorf("Got %q, expected %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+
+	pconn, _ := NewConnection(addr, "test")
+	pconn.Demand("TcasData")
+	running := make(chan bool)
+	pconn.OnStateChange(func(_, newState ConnState) {
+		if newState == StateRunning {
+			close(running)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pconn.Run(ctx)
+	<-running
+
+	expectDemand("demand=Qs440")
+	if err := pconn.Demand("UplinkBits"); err != NotDemandModeError {
+		t.Errorf("Demanding a non-demand variable returned %v", err)
+	}
+
+	// a second demand is only counted, so the first undemand is too.
+	pconn.Demand("TcasData")
+	pconn.Undemand("TcasData")
+	if names := pconn.Demands(); len(names) != 1 || names[0] != "TcasData" {
+		t.Errorf("Unexpected demands: %v", names)
+	}
+	pconn.Undemand("TcasData")
+	expectDemand("nodemand=Qs440")
+	if names := pconn.Demands(); len(names) != 0 {
+		t.Errorf("Unexpected demands after withdrawal: %v", names)
+	}
+}
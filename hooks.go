@@ -0,0 +1,201 @@
+package psx
+
+import (
+	"path"
+	"strings"
+)
+
+// HookID identifies a hook registered with AddHook so it can be removed
+// again with RemoveHook.
+type HookID uint64
+
+// MessagePredicates select the messages a predicate hook is called for.
+type MessagePredicate func(msg *WireMsg) bool
+
+// a single hook registered with AddHook or one of the matching variants.
+// match is nil for keyed and catch-all hooks.
+type hookEntry struct {
+	id    HookID
+	match MessagePredicate
+	fn    MessageHook
+}
+
+// invoke the callbacks for hookName.
+//
+// The hook in the Hooks map (if any) is called first, followed by the hooks
+// registered with AddHook in the order they were added, then any matching
+// pattern, prefix, type, mode or predicate hooks in the order they were
+// added.  Finally, if the lexicon has no definition for the message, the
+// catch-all hooks are called.
+func (pconn *Connection) callHook(hookName string, msg *WireMsg) {
+	pconn.hooksMu.RLock()
+	callback, found := pconn.Hooks[hookName]
+	hooks := pconn.hookList[hookName]
+	matchHooks := pconn.matchHooks
+	catchAllHooks := pconn.catchAllHooks
+	pconn.hooksMu.RUnlock()
+
+	if found && callback != nil {
+		callback(pconn, msg)
+	}
+	for _, hook := range hooks {
+		hook.fn(pconn, msg)
+	}
+	for _, hook := range matchHooks {
+		if hook.match(msg) {
+			hook.fn(pconn, msg)
+		}
+	}
+	if len(catchAllHooks) > 0 && msg.GetDefinition() == nil {
+		for _, hook := range catchAllHooks {
+			hook.fn(pconn, msg)
+		}
+	}
+}
+
+// Set the hook for the named (decoded) attribute, replacing any existing
+// hook.  Setting a nil hook removes it.
+//
+// This manages the same single hook per attribute as the Hooks map, but
+// unlike modifying Hooks directly, is safe whilst the Listener is running.
+// Hooks added with AddHook are unaffected.
+func (pconn *Connection) SetHook(hookName string, hook MessageHook) {
+	pconn.hooksMu.Lock()
+	defer pconn.hooksMu.Unlock()
+	if hook == nil {
+		delete(pconn.Hooks, hookName)
+	} else {
+		pconn.Hooks[hookName] = hook
+	}
+}
+
+// Add a hook for the named (decoded) attribute, and return an id that can
+// be used to remove it again.
+//
+// Any number of hooks can be added for an attribute.  They are called in
+// the order they were added, after the hook in the Hooks map.  AddHook is
+// safe to call whilst the Listener is running.
+func (pconn *Connection) AddHook(hookName string, hook MessageHook) HookID {
+	pconn.hooksMu.Lock()
+	defer pconn.hooksMu.Unlock()
+	if pconn.hookList == nil {
+		pconn.hookList = make(map[string][]hookEntry)
+		pconn.hookKeys = make(map[HookID]string)
+	}
+	pconn.lastHookId++
+	id := pconn.lastHookId
+
+	pconn.hookList[hookName] = appendHook(pconn.hookList[hookName], hookEntry{id: id, fn: hook})
+	pconn.hookKeys[id] = hookName
+	return id
+}
+
+// returns a new slice with hook on the end of hooks.
+func appendHook(hooks []hookEntry, hook hookEntry) []hookEntry {
+	newHooks := make([]hookEntry, len(hooks), len(hooks)+1)
+	copy(newHooks, hooks)
+	return append(newHooks, hook)
+}
+
+// returns a new slice without the hook with the given id, and whether it was
+// found.
+func removeHook(hooks []hookEntry, id HookID) ([]hookEntry, bool) {
+	newHooks := make([]hookEntry, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.id != id {
+			newHooks = append(newHooks, hook)
+		}
+	}
+	return newHooks, len(newHooks) != len(hooks)
+}
+
+// Add a hook that is called for every message pred returns true for.
+//
+// pred is called on the Listener goroutine for every message received, so
+// should be cheap.
+func (pconn *Connection) AddPredicateHook(pred MessagePredicate, hook MessageHook) HookID {
+	pconn.hooksMu.Lock()
+	defer pconn.hooksMu.Unlock()
+	pconn.lastHookId++
+	id := pconn.lastHookId
+	pconn.matchHooks = appendHook(pconn.matchHooks, hookEntry{id: id, match: pred, fn: hook})
+	return id
+}
+
+// Add a hook that is called for every message whose (decoded) key matches
+// the glob pattern, eg: "Lights*".  The pattern syntax is that of path.Match.
+func (pconn *Connection) AddPatternHook(pattern string, hook MessageHook) (HookID, error) {
+	// check the pattern up front so we don't fail silently later.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	return pconn.AddPredicateHook(func(msg *WireMsg) bool {
+		matched, _ := path.Match(pattern, msg.GetDecodedKey())
+		return matched
+	}, hook), nil
+}
+
+// Add a hook that is called for every message whose (decoded) key starts
+// with prefix.
+func (pconn *Connection) AddPrefixHook(prefix string, hook MessageHook) HookID {
+	return pconn.AddPredicateHook(func(msg *WireMsg) bool {
+		return strings.HasPrefix(msg.GetDecodedKey(), prefix)
+	}, hook)
+}
+
+// Add a hook that is called for every message whose lexicon definition has
+// the given MessageType (one of the MsgType constants).
+func (pconn *Connection) AddTypeHook(msgType int, hook MessageHook) HookID {
+	return pconn.AddPredicateHook(func(msg *WireMsg) bool {
+		def := msg.GetDefinition()
+		return def != nil && def.MessageType == msgType
+	}, hook)
+}
+
+// Add a hook that is called for every message whose lexicon definition has
+// the given MessageMode (one of the MsgMode constants).
+func (pconn *Connection) AddModeHook(msgMode int, hook MessageHook) HookID {
+	return pconn.AddPredicateHook(func(msg *WireMsg) bool {
+		def := msg.GetDefinition()
+		return def != nil && def.MessageMode == msgMode
+	}, hook)
+}
+
+// Add a hook that is called for every message the lexicon can't decode -
+// Q variables the lexicon doesn't know, and the server's other messages
+// (id, load1, ...).  It's called whether or not any other hook matched.
+func (pconn *Connection) AddCatchAllHook(hook MessageHook) HookID {
+	pconn.hooksMu.Lock()
+	defer pconn.hooksMu.Unlock()
+	pconn.lastHookId++
+	id := pconn.lastHookId
+	pconn.catchAllHooks = appendHook(pconn.catchAllHooks, hookEntry{id: id, fn: hook})
+	return id
+}
+
+// Remove a hook previously added with AddHook or any of the other Add*Hook
+// methods.  Returns false if there was no such hook.
+func (pconn *Connection) RemoveHook(id HookID) bool {
+	pconn.hooksMu.Lock()
+	defer pconn.hooksMu.Unlock()
+	var found bool
+	if pconn.matchHooks, found = removeHook(pconn.matchHooks, id); found {
+		return true
+	}
+	if pconn.catchAllHooks, found = removeHook(pconn.catchAllHooks, id); found {
+		return true
+	}
+	hookName, found := pconn.hookKeys[id]
+	if !found {
+		return false
+	}
+	delete(pconn.hookKeys, id)
+
+	hooks, _ := removeHook(pconn.hookList[hookName], id)
+	if len(hooks) == 0 {
+		delete(pconn.hookList, hookName)
+	} else {
+		pconn.hookList[hookName] = hooks
+	}
+	return true
+}
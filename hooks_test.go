@@ -0,0 +1,107 @@
+package psx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHookOrder(t *testing.T) {
+	pconn, _ := NewConnection("localhost:10747", "test")
+	var calls []string
+	record := func(name string) MessageHook {
+		return func(_ *Connection, _ *WireMsg) {
+			calls = append(calls, name)
+		}
+	}
+	first := pconn.AddHook("UplinkBits", record("first"))
+	pconn.AddHook("UplinkBits", record("second"))
+	pconn.Hooks["UplinkBits"] = record("map")
+	pconn.AddHook("KeybCduC", record("other"))
+
+	msg := parseMsg(pconn.lex, "Qi242=1")
+	pconn.callHook("UplinkBits", msg)
+	if got := strings.Join(calls, ","); got != "map,first,second" {
+		t.Errorf("Unexpected hook order: %s", got)
+	}
+
+	calls = nil
+	if !pconn.RemoveHook(first) {
+		t.Error("Couldn't remove hook")
+	}
+	if pconn.RemoveHook(first) {
+		t.Error("Removed the same hook twice")
+	}
+	pconn.SetHook("UplinkBits", nil)
+	pconn.callHook("UplinkBits", msg)
+	if got := strings.Join(calls, ","); got != "second" {
+		t.Errorf("Unexpected hooks after removal: %s", got)
+	}
+}
+
+func TestMatchingHooks(t *testing.T) {
+	pconn, _ := NewConnection("localhost:10747", "test")
+	pconn.lex.parse(parseMsg(nil, "Lh402(K)=KeybCduC"))
+	pconn.lex.parse(parseMsg(nil, "Li242(Z)=UplinkBits"))
+	pconn.lex.parse(parseMsg(nil, "Lh100(D)=LightsTaxi"))
+
+	var calls []string
+	record := func(name string) MessageHook {
+		return func(_ *Connection, msg *WireMsg) {
+			calls = append(calls, name+":"+msg.GetDecodedKey())
+		}
+	}
+	if _, err := pconn.AddPatternHook("[", record("bad")); err == nil {
+		t.Error("Invalid pattern wasn't rejected")
+	}
+	pattern, _ := pconn.AddPatternHook("*Cdu*", record("pattern"))
+	pconn.AddPrefixHook("Lights", record("prefix"))
+	pconn.AddTypeHook(MsgTypeI, record("type"))
+	pconn.AddModeHook(MsgModeDelta, record("mode"))
+	pconn.AddPredicateHook(func(msg *WireMsg) bool {
+		return msg.Value == "99"
+	}, record("pred"))
+	pconn.AddCatchAllHook(record("all"))
+
+	for _, line := range []string{"Qh402=1", "Qi242=2", "Qh100=99", "Qs999=3", "load1"} {
+		msg := parseMsg(pconn.lex, line)
+		pconn.callHook(msg.GetDecodedKey(), msg)
+	}
+	expected := "pattern:KeybCduC,type:UplinkBits,prefix:LightsTaxi,mode:LightsTaxi,pred:LightsTaxi,all:Qs999,all:load1"
+	if got := strings.Join(calls, ","); got != expected {
+		t.Errorf("Unexpected hook calls:\n got: %s\nwant: %s", got, expected)
+	}
+
+	calls = nil
+	pconn.RemoveHook(pattern)
+	msg := parseMsg(pconn.lex, "Qh402=1")
+	pconn.callHook(msg.GetDecodedKey(), msg)
+	// a known key is not caught, even with no other hook for it.
+	if got := strings.Join(calls, ","); got != "" {
+		t.Errorf("Unexpected hook calls after removal: %s", got)
+	}
+}
+
+func TestCatchAllWithPattern(t *testing.T) {
+	pconn, _ := NewConnection("localhost:10747", "test")
+	pconn.lex.parse(parseMsg(nil, "Li242(Z)=UplinkBits"))
+
+	var calls []string
+	record := func(name string) MessageHook {
+		return func(_ *Connection, msg *WireMsg) {
+			calls = append(calls, name+":"+msg.GetDecodedKey())
+		}
+	}
+	// a pattern matching everything mustn't hide unknown keys from the
+	// catch-all.
+	pconn.AddPatternHook("*", record("pattern"))
+	pconn.AddCatchAllHook(record("all"))
+
+	for _, line := range []string{"Qi242=1", "Qs999=2"} {
+		msg := parseMsg(pconn.lex, line)
+		pconn.callHook(msg.GetDecodedKey(), msg)
+	}
+	expected := "pattern:UplinkBits,pattern:Qs999,all:Qs999"
+	if got := strings.Join(calls, ","); got != expected {
+		t.Errorf("Unexpected hook calls:\n got: %s\nwant: %s", got, expected)
+	}
+}
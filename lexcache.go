@@ -0,0 +1,184 @@
+package psx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// A lexicon cache file couldn't be parsed.
+	LexiconCacheSyntaxError = errors.New("Malformed lexicon cache")
+)
+
+// LexiconMismatch reports a difference between a preloaded lexicon and the
+// lexicon sent by the server on connecting.
+type LexiconMismatch struct {
+	CachedVersion string      // the server version the cache was saved from, if known
+	Version       string      // the version of the server we're connected to
+	Cached        *MessageDef // the preloaded definition, nil if the server added it
+	Live          *MessageDef // the server's definition, nil if the server dropped it
+}
+
+// the definition the mismatch is about, for ordering.
+func (m LexiconMismatch) def() *MessageDef {
+	if m.Live != nil {
+		return m.Live
+	}
+	return m.Cached
+}
+
+func (m LexiconMismatch) String() string {
+	if m.Cached == nil {
+		return fmt.Sprintf("%s added in %s", m.Live.LexiconString(), m.Version)
+	}
+	cached := m.Cached.LexiconString()
+	if m.CachedVersion != "" {
+		cached += " (from " + m.CachedVersion + ")"
+	}
+	if m.Live == nil {
+		return fmt.Sprintf("%s removed in %s", cached, m.Version)
+	}
+	return fmt.Sprintf("%s changed to %s in %s", cached, m.Live.LexiconString(), m.Version)
+}
+
+// LexiconMismatchHooks are called for each difference found between a
+// preloaded lexicon and the live one.
+type LexiconMismatchHook func(pconn *Connection, mismatch LexiconMismatch)
+
+// write the lexicon in cache format - a version line followed by the
+// lexicon lines, as the server sends them.
+func (lex *Lexicon) writeCache(w io.Writer, version string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version=%s\n", version)
+	for _, md := range lex.definitions() {
+		fmt.Fprintln(bw, md.LexiconString())
+	}
+	return bw.Flush()
+}
+
+// read a lexicon in cache format.
+func readLexiconCache(r io.Reader) (version string, defs []*MessageDef, err error) {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		msg := parseMsg(nil, line)
+		if lineNo == 1 {
+			if msg.GetKey() != "version" {
+				return "", nil, fmt.Errorf("%w: line 1: missing version", LexiconCacheSyntaxError)
+			}
+			version = msg.Value
+			continue
+		}
+		md, err := parseLexicon(msg)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: line %d: %q", LexiconCacheSyntaxError, lineNo, line)
+		}
+		defs = append(defs, md)
+	}
+	if err = scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if lineNo == 0 {
+		return "", nil, fmt.Errorf("%w: empty file", LexiconCacheSyntaxError)
+	}
+	return version, defs, nil
+}
+
+// Read a lexicon cache file saved with SaveLexicon, returning the lexicon
+// and the version of the server it came from.
+func LoadLexicon(path string) (lex *Lexicon, version string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	version, defs, err := readLexiconCache(f)
+	if err != nil {
+		return nil, "", err
+	}
+	lex = NewLexicon()
+	for _, md := range defs {
+		lex.add(md)
+	}
+	return lex, version, nil
+}
+
+// Load a lexicon saved with SaveLexicon, so names can be used before the
+// server has sent its lexicon.
+//
+// When the connection is established, the live lexicon is checked against
+// the preloaded one, reporting any differences to OnLexiconMismatch.  The
+// live lexicon always wins.
+func (pconn *Connection) PreloadLexicon(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	version, defs, err := readLexiconCache(f)
+	if err != nil {
+		return err
+	}
+	pconn.preload(version, defs)
+	return nil
+}
+
+// add the definitions to the lexicon, and keep them to check the server's
+// lexicon against.
+func (pconn *Connection) preload(version string, defs []*MessageDef) {
+	cache := make(map[string]*MessageDef, len(defs))
+	for _, md := range defs {
+		pconn.lex.add(md)
+		cache[md.KeyString()] = md
+	}
+	pconn.mu.Lock()
+	pconn.lexCache = cache
+	pconn.lexCacheVersion = version
+	pconn.mu.Unlock()
+}
+
+// Save the current lexicon, and the version of the server it came from, to
+// path.
+func (pconn *Connection) SaveLexicon(path string) error {
+	// write then rename, so a crash can't leave a truncated cache behind.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	err = pconn.lex.writeCache(f, pconn.Version())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Preload the lexicon from the cache file at path, if it exists, and save
+// the lexicon back to it whenever the server's lexicon differs.
+//
+// This gives a warm start: human names resolve from the moment the
+// Connection is created, rather than once the lexicon has been learned.
+func (pconn *Connection) UseLexiconCache(path string) error {
+	err := pconn.PreloadLexicon(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	pconn.mu.Lock()
+	pconn.lexCachePath = path
+	pconn.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,106 @@
+package psx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLexiconCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lexicon.txt")
+	cached := "version=9.0.0\nLh402(K)=KeybCduC\nLi242(E)=UplinkBits\nLi300(E)=OldThing\n"
+	if err := os.WriteFile(path, []byte(cached), 0644); err != nil {
+		t.Fatalf("Couldn't write cache: %s", err)
+	}
+
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("exit\r\n"))
+	})
+	pconn, _ := NewConnection(addr, "test")
+	if err := pconn.UseLexiconCache(path); err != nil {
+		t.Fatalf("Couldn't load cache: %s", err)
+	}
+	// names resolve before we've ever connected.
+	if key := pconn.NewPair("OldThing", "1").GetKey(); key != "Qi300" {
+		t.Errorf("Preloaded name encoded as %q, expected Qi300", key)
+	}
+
+	var mismatches []string
+	pconn.OnLexiconMismatch = func(_ *Connection, mismatch LexiconMismatch) {
+		mismatches = append(mismatches, mismatch.String())
+	}
+	if err := pconn.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	want := []string{
+		"Li242(E)=UplinkBits (from 9.0.0) changed to Li242(Z)=UplinkBits in 10.0.0",
+		"Li300(E)=OldThing (from 9.0.0) removed in 10.0.0",
+	}
+	if len(mismatches) != len(want) {
+		t.Fatalf("Got mismatches %q, expected %q", mismatches, want)
+	}
+	for i := range want {
+		if mismatches[i] != want[i] {
+			t.Errorf("Mismatch %d is %q, expected %q", i, mismatches[i], want[i])
+		}
+	}
+	if key := pconn.NewPair("OldThing", "1").GetKey(); key != "OldThing" {
+		t.Errorf("Removed name still encoded as %q", key)
+	}
+
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Couldn't read cache back: %s", err)
+	}
+	if string(saved) != "version=10.0.0\nLi242(Z)=UplinkBits\nLh402(K)=KeybCduC\n" {
+		t.Errorf("Unexpected cache contents:\n%s", saved)
+	}
+}
+
+func TestLexiconCacheErrors(t *testing.T) {
+	pconn, _ := NewConnection("localhost:0", "test")
+	dir := t.TempDir()
+
+	// a missing cache is fine for UseLexiconCache, but not PreloadLexicon.
+	missing := filepath.Join(dir, "missing.txt")
+	if err := pconn.UseLexiconCache(missing); err != nil {
+		t.Errorf("UseLexiconCache failed on a missing file: %s", err)
+	}
+	if err := pconn.PreloadLexicon(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("PreloadLexicon on a missing file returned %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("version=10.0.0\nQi242=5\n"), 0644)
+	if err := pconn.PreloadLexicon(bad); !errors.Is(err, LexiconCacheSyntaxError) {
+		t.Errorf("Malformed cache returned %v", err)
+	}
+}
+
+func TestLexiconCacheSaveError(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("exit\r\n"))
+	})
+	pconn, _ := NewConnection(addr, "test")
+	// the directory doesn't exist, so the cache can't be written.
+	path := filepath.Join(t.TempDir(), "missing", "lexicon.txt")
+	if err := pconn.UseLexiconCache(path); err != nil {
+		t.Fatalf("UseLexiconCache failed: %s", err)
+	}
+	var lexErrs []*LexiconError
+	pconn.OnLexiconError = func(_ *Connection, err *LexiconError) {
+		lexErrs = append(lexErrs, err)
+	}
+	if err := pconn.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if len(lexErrs) != 1 || lexErrs[0].Line != "" || !errors.Is(lexErrs[0], os.ErrNotExist) {
+		t.Errorf("Got lexicon errors %v, expected a cache write failure", lexErrs)
+	}
+}
@@ -2,14 +2,18 @@ package psx
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
 	DuplicateNameError  = errors.New("lexicon Name Already Registered")
 	DuplicateIndexError = errors.New("lexicon Index Already Registered")
-	lexiconSyntaxError  = errors.New("lexicon Line Has Invalid Syntax")
+	LexiconSyntaxError  = errors.New("lexicon Line Has Invalid Syntax")
+	UnknownModeError    = errors.New("lexicon Line Has Unknown Mode")
 	NotAQStringError    = errors.New("Line cannot be decoded - not a Q string")
 	QStringSyntaxError  = errors.New("Malformed Q String")
 	UnknownIndexError   = errors.New("Index not known to lexicon")
@@ -64,16 +68,69 @@ func (msgdef *MessageDef) KeyString() string {
 	return ""
 }
 
+// Returns true if key is a Q variable wire key (Qi/Qs/Qh)
+func IsQKey(key string) bool {
+	return len(key) > 2 && key[0] == 'Q' && (key[1] == 'i' || key[1] == 's' || key[1] == 'h')
+}
+
+// orders definitions by type, then index.
+func (msgdef *MessageDef) less(other *MessageDef) bool {
+	if msgdef.MessageType != other.MessageType {
+		return msgdef.MessageType < other.MessageType
+	}
+	return msgdef.Index < other.Index
+}
+
+// the mode suffix letters, indexed by MsgMode constant
+const msgModeLetters = "SCEDBMGFKRAXYZN"
+
+// Returns the letter used for this message's mode in lexicon lines (eg:
+// "Z" for MsgModeXecon), or the empty string if the mode is unknown.
+func (msgdef *MessageDef) ModeString() string {
+	if msgdef.MessageMode < 0 || msgdef.MessageMode >= len(msgModeLetters) {
+		return ""
+	}
+	return msgModeLetters[msgdef.MessageMode : msgdef.MessageMode+1]
+}
+
+// Returns the lexicon line that defines this message, as the server would
+// send it (eg: "Li242(Z)=UplinkBits").
+func (msgdef *MessageDef) LexiconString() string {
+	key, mode := msgdef.KeyString(), msgdef.ModeString()
+	if key == "" || mode == "" {
+		return ""
+	}
+	return "L" + key[1:] + "(" + mode + ")=" + msgdef.HumanName
+}
+
+// LexiconError reports a lexicon line from the server that couldn't be
+// learned, or a learned lexicon that couldn't be saved to the cache.
+type LexiconError struct {
+	Line string // the offending lexicon line, empty if saving the cache failed
+	Err  error  // why it was rejected
+}
+
+func (e *LexiconError) Error() string {
+	if e.Line == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %q", e.Err, e.Line)
+}
+
+func (e *LexiconError) Unwrap() error {
+	return e.Err
+}
+
 // parse a raw lexicon Line from a server into a defintion.
 func parseLexicon(lexMsg *WireMsg) (msgdef *MessageDef, err error) {
 	msgdef = new(MessageDef)
 	key := lexMsg.GetKey()
-	if key[0] != 'L' {
-		return nil, lexiconSyntaxError
+	if !lexMsg.HasValue || lexMsg.Value == "" || key == "" || key[0] != 'L' {
+		return nil, LexiconSyntaxError
 	}
 	// must be at least 6 charts long for the L + type (2 chars), the index (1 char min) and mode suffix (3 chars).
 	if len(key) < 6 {
-		return nil, lexiconSyntaxError
+		return nil, LexiconSyntaxError
 	}
 	// parse type.
 	// naive parse should be enough.
@@ -85,16 +142,16 @@ func parseLexicon(lexMsg *WireMsg) (msgdef *MessageDef, err error) {
 	case 'h':
 		msgdef.MessageType = MsgTypeH
 	default:
-		return nil, lexiconSyntaxError
+		return nil, LexiconSyntaxError
 	}
 	// now, split out the number
 	suffixIdx := strings.Index(key, "(")
-	if suffixIdx < 0 {
-		return nil, lexiconSyntaxError
+	if suffixIdx < 0 || suffixIdx+1 >= len(key) {
+		return nil, LexiconSyntaxError
 	}
 	msgdef.Index, err = strconv.Atoi(key[2:suffixIdx])
 	if err != nil {
-		return nil, err
+		return nil, LexiconSyntaxError
 	}
 	// and the type
 	switch key[suffixIdx+1] {
@@ -129,35 +186,45 @@ func parseLexicon(lexMsg *WireMsg) (msgdef *MessageDef, err error) {
 	case 'N':
 		msgdef.MessageMode = MsgModeDemand
 	default:
-		return nil, lexiconSyntaxError
+		return nil, UnknownModeError
 	}
 	msgdef.HumanName = lexMsg.Value
 	return msgdef, nil
 }
 
-// A lexicon holds the data necessary to dynamically learn and map the PSX
+// A Lexicon holds the data necessary to dynamically learn and map the PSX
 // lexicon for Qi/Qh/Qs messages so we can use the human names internally
 //
 // This allows for (hopefully) less painful to read code.
-type lexicon struct {
+//
+// The Lexicon is safe for concurrent use - the Listener learns new entries
+// whilst other goroutines are building messages or querying it.  Definitions
+// are returned by value, so they can't be used to modify the Lexicon.
+type Lexicon struct {
+	mu      sync.RWMutex
 	forward map[string]*MessageDef // forward lookup stores the Qh/Qs/Qi to messagedef map
 	reverse map[string]*MessageDef // reverse lookup stores the humanName to Qh/Qs/Qi map
 }
 
-// initialise a new, empty, lexicon ready to be filled with mappings
-func newLexicon() (lex *lexicon) {
-	lex = new(lexicon)
-	lex.forward = make(map[string]*MessageDef, 0)
-	lex.reverse = make(map[string]*MessageDef, 0)
+// initialise a new lexicon holding the given definitions.  Later
+// definitions replace earlier ones with the same name or key.
+func NewLexicon(defs ...MessageDef) (lex *Lexicon) {
+	lex = new(Lexicon)
+	lex.forward = make(map[string]*MessageDef, len(defs))
+	lex.reverse = make(map[string]*MessageDef, len(defs))
+	for i := range defs {
+		def := defs[i]
+		lex.add(&def)
+	}
 
 	return lex
 }
 
 // Finds the Q key for a given named paramater.  returns the empty string
 // if it can't find it.
-func (lex *lexicon) keyFor(humanName string) string {
-	def, found := lex.reverse[humanName]
-	if found {
+func (lex *Lexicon) keyFor(humanName string) string {
+	def := lex.lookupName(humanName)
+	if def != nil {
 		return def.KeyString()
 	} else {
 		return ""
@@ -166,22 +233,193 @@ func (lex *lexicon) keyFor(humanName string) string {
 
 // given a Qstring, find the human name.  returns the empty string if it can't
 // find the mapping.
-func (lex *lexicon) humanNameFor(keyName string) string {
-	def, found := lex.forward[keyName]
-	if found {
+func (lex *Lexicon) humanNameFor(keyName string) string {
+	def := lex.lookupKey(keyName)
+	if def != nil {
 		return def.HumanName
 	} else {
 		return ""
 	}
 }
 
-func (lex *lexicon) parse(msgIn *WireMsg) (err error) {
+// returns the definition for the given Q key, or nil if it's unknown.
+func (lex *Lexicon) lookupKey(keyName string) *MessageDef {
+	lex.mu.RLock()
+	defer lex.mu.RUnlock()
+	return lex.forward[keyName]
+}
+
+// returns the definition for the given human name, or nil if it's unknown.
+func (lex *Lexicon) lookupName(humanName string) *MessageDef {
+	lex.mu.RLock()
+	defer lex.mu.RUnlock()
+	return lex.reverse[humanName]
+}
+
+func (lex *Lexicon) parse(msgIn *WireMsg) (err error) {
 	md, err := parseLexicon(msgIn)
 	if err != nil {
 		return err
 	}
+	lex.add(md)
+	return nil
+}
+
+// add (or replace) a definition in the lexicon.
+func (lex *Lexicon) add(md *MessageDef) {
+	lex.mu.Lock()
+	defer lex.mu.Unlock()
+	// when relearning from a different server version, a name or key may
+	// have moved - drop the stale half of any old mapping first.
+	if old, found := lex.reverse[md.HumanName]; found {
+		delete(lex.forward, old.KeyString())
+	}
+	if old, found := lex.forward[md.KeyString()]; found {
+		delete(lex.reverse, old.HumanName)
+	}
 	lex.reverse[md.HumanName] = md
 	lex.forward[md.KeyString()] = md
+}
 
-	return nil
+// remove the definition for the given Q key, if there is one.
+func (lex *Lexicon) remove(keyName string) {
+	lex.mu.Lock()
+	defer lex.mu.Unlock()
+	if old, found := lex.forward[keyName]; found {
+		delete(lex.forward, keyName)
+		delete(lex.reverse, old.HumanName)
+	}
+}
+
+// returns all of the definitions in the lexicon, ordered by type then index.
+func (lex *Lexicon) definitions() []*MessageDef {
+	lex.mu.RLock()
+	defs := make([]*MessageDef, 0, len(lex.forward))
+	for _, md := range lex.forward {
+		defs = append(defs, md)
+	}
+	lex.mu.RUnlock()
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].less(defs[j])
+	})
+	return defs
+}
+
+// Returns the definition for the given wire key (eg: "Qi242").
+func (lex *Lexicon) LookupKey(keyName string) (def MessageDef, found bool) {
+	if md := lex.lookupKey(keyName); md != nil {
+		return *md, true
+	}
+	return def, false
+}
+
+// Returns the definition for the given human name (eg: "UplinkBits").
+func (lex *Lexicon) LookupName(humanName string) (def MessageDef, found bool) {
+	if md := lex.lookupName(humanName); md != nil {
+		return *md, true
+	}
+	return def, false
+}
+
+// Returns a new (blank) WireMsg linked to the lexicon, for working with
+// messages away from a Connection - eg: from files.
+func (lex *Lexicon) NewWireMsg() *WireMsg {
+	return newWireMsg(lex)
+}
+
+// Returns the number of definitions in the lexicon.
+func (lex *Lexicon) Len() int {
+	lex.mu.RLock()
+	defer lex.mu.RUnlock()
+	return len(lex.forward)
+}
+
+// Returns every definition in the lexicon, ordered by type then index.
+func (lex *Lexicon) Definitions() []MessageDef {
+	return lex.Filter(nil)
+}
+
+// Calls fn for each definition in the lexicon, ordered by type then index,
+// until fn returns false.
+func (lex *Lexicon) Range(fn func(def MessageDef) bool) {
+	for _, md := range lex.definitions() {
+		if !fn(*md) {
+			return
+		}
+	}
+}
+
+// Returns the definitions for which match returns true, ordered by type then
+// index.  A nil match returns every definition.
+func (lex *Lexicon) Filter(match func(def MessageDef) bool) []MessageDef {
+	defs := lex.definitions()
+	result := make([]MessageDef, 0, len(defs))
+	for _, md := range defs {
+		if match == nil || match(*md) {
+			result = append(result, *md)
+		}
+	}
+	return result
+}
+
+// Returns the definitions of the given type (one of the MsgType constants).
+func (lex *Lexicon) ByType(msgType int) []MessageDef {
+	return lex.Filter(func(def MessageDef) bool {
+		return def.MessageType == msgType
+	})
+}
+
+// Returns the definitions with the given mode (one of the MsgMode
+// constants).
+func (lex *Lexicon) ByMode(msgMode int) []MessageDef {
+	return lex.Filter(func(def MessageDef) bool {
+		return def.MessageMode == msgMode
+	})
+}
+
+// Returns the definitions whose human name contains substr, ignoring case.
+func (lex *Lexicon) Search(substr string) []MessageDef {
+	substr = strings.ToLower(substr)
+	return lex.Filter(func(def MessageDef) bool {
+		return strings.Contains(strings.ToLower(def.HumanName), substr)
+	})
+}
+
+// LexiconRemap is a name whose definition differs between two lexicons.
+type LexiconRemap struct {
+	Old MessageDef
+	New MessageDef
+}
+
+// LexiconDiff holds the differences between two lexicons, as found by Diff.
+// Each list is ordered by type then index.
+type LexiconDiff struct {
+	Added    []MessageDef   // names only in the other lexicon
+	Removed  []MessageDef   // names only in this lexicon
+	Remapped []LexiconRemap // names whose key or mode changed
+}
+
+// Reports true if the lexicons were identical.
+func (diff *LexiconDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Remapped) == 0
+}
+
+// Compare this lexicon against other (eg: from a newer PSX version),
+// matching definitions by human name.
+func (lex *Lexicon) Diff(other *Lexicon) (diff LexiconDiff) {
+	for _, md := range lex.definitions() {
+		otherDef := other.lookupName(md.HumanName)
+		switch {
+		case otherDef == nil:
+			diff.Removed = append(diff.Removed, *md)
+		case *otherDef != *md:
+			diff.Remapped = append(diff.Remapped, LexiconRemap{Old: *md, New: *otherDef})
+		}
+	}
+	for _, md := range other.definitions() {
+		if lex.lookupName(md.HumanName) == nil {
+			diff.Added = append(diff.Added, *md)
+		}
+	}
+	return diff
 }
@@ -1,6 +1,7 @@
 package psx
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -62,8 +63,24 @@ func TestKeystring(t *testing.T) {
 	}
 }
 
+func TestLexiconString(t *testing.T) {
+	for _, line := range []string{"Lh402(K)=KeybCduC", "Li242(Z)=UplinkBits", "Ls121(E)=PiBaHeAlTas"} {
+		md, err := parseLexicon(parseMsg(nil, line))
+		if err != nil {
+			t.Fatalf("Failed to parse %s with error: %s", line, err)
+		}
+		if md.LexiconString() != line {
+			t.Errorf("Unexpected Lexicon String: \"%s\"", md.LexiconString())
+		}
+	}
+	md := &MessageDef{MessageType: MsgTypeI, MessageMode: len(msgModeLetters), Index: 1, HumanName: "Odd"}
+	if md.ModeString() != "" || md.LexiconString() != "" {
+		t.Errorf("Unknown mode produced %q, %q", md.ModeString(), md.LexiconString())
+	}
+}
+
 func TestLexicon(t *testing.T) {
-	lex := newLexicon()
+	lex := NewLexicon()
 	err := lex.parse(parseMsg(nil, "Lh402(K)=KeybCduC"))
 	if err != nil {
 		t.Fatalf("Couldn't add Lexicon Line: %s", err)
@@ -91,7 +108,7 @@ func TestLexicon(t *testing.T) {
 }
 
 func TestLexiconEncode(t *testing.T) {
-	lex := newLexicon()
+	lex := NewLexicon()
 	err := lex.parse(parseMsg(nil, "Lh402(K)=KeybCduC"))
 	if err != nil {
 		t.Fatalf("Couldn't add Lexicon Line: %s", err)
@@ -113,3 +130,67 @@ func TestLexiconEncode(t *testing.T) {
 		t.Errorf("Got unexpected display format: %s", msg)
 	}
 }
+
+func TestLexiconQueries(t *testing.T) {
+	lex := NewLexicon(
+		MessageDef{MessageType: MsgTypeH, MessageMode: MsgModeCdukeyb, Index: 402, HumanName: "KeybCduC"},
+		MessageDef{MessageType: MsgTypeI, MessageMode: MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+		MessageDef{MessageType: MsgTypeH, MessageMode: MsgModeCdukeyb, Index: 401, HumanName: "KeybCduR"},
+		MessageDef{MessageType: MsgTypeS, MessageMode: MsgModeEcon, Index: 121, HumanName: "PiBaHeAlTas"},
+	)
+
+	if def, found := lex.LookupKey("Qi242"); !found || def.HumanName != "UplinkBits" {
+		t.Errorf("LookupKey returned %v, %v", def, found)
+	}
+	if def, found := lex.LookupName("KeybCduC"); !found || def.KeyString() != "Qh402" {
+		t.Errorf("LookupName returned %v, %v", def, found)
+	}
+	if _, found := lex.LookupName("Nothing"); found {
+		t.Errorf("LookupName found an unknown name")
+	}
+
+	var names []string
+	lex.Range(func(def MessageDef) bool {
+		names = append(names, def.HumanName)
+		return true
+	})
+	if strings.Join(names, ",") != "UplinkBits,PiBaHeAlTas,KeybCduR,KeybCduC" {
+		t.Errorf("Unexpected iteration order: %v", names)
+	}
+	if defs := lex.ByMode(MsgModeCdukeyb); len(defs) != 2 || defs[0].Index != 401 {
+		t.Errorf("ByMode returned %v", defs)
+	}
+	if defs := lex.ByType(MsgTypeS); len(defs) != 1 || defs[0].HumanName != "PiBaHeAlTas" {
+		t.Errorf("ByType returned %v", defs)
+	}
+	if defs := lex.Search("keybcdu"); len(defs) != 2 {
+		t.Errorf("Search returned %v", defs)
+	}
+}
+
+func TestLexiconDiff(t *testing.T) {
+	old := NewLexicon(
+		MessageDef{MessageType: MsgTypeH, MessageMode: MsgModeCdukeyb, Index: 402, HumanName: "KeybCduC"},
+		MessageDef{MessageType: MsgTypeI, MessageMode: MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+		MessageDef{MessageType: MsgTypeI, MessageMode: MsgModeEcon, Index: 300, HumanName: "OldThing"},
+	)
+	updated := NewLexicon(
+		MessageDef{MessageType: MsgTypeH, MessageMode: MsgModeCdukeyb, Index: 402, HumanName: "KeybCduC"},
+		MessageDef{MessageType: MsgTypeI, MessageMode: MsgModeXecon, Index: 243, HumanName: "UplinkBits"},
+		MessageDef{MessageType: MsgTypeS, MessageMode: MsgModeDemand, Index: 440, HumanName: "TcasData"},
+	)
+
+	diff := old.Diff(updated)
+	if len(diff.Added) != 1 || diff.Added[0].HumanName != "TcasData" {
+		t.Errorf("Unexpected additions: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].HumanName != "OldThing" {
+		t.Errorf("Unexpected removals: %v", diff.Removed)
+	}
+	if len(diff.Remapped) != 1 || diff.Remapped[0].Old.Index != 242 || diff.Remapped[0].New.Index != 243 {
+		t.Errorf("Unexpected remappings: %v", diff.Remapped)
+	}
+	if diff := old.Diff(old); !diff.Empty() {
+		t.Errorf("Lexicon differs from itself: %v", diff)
+	}
+}
@@ -0,0 +1,148 @@
+package psx
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LexiconStats counts the lexicon lines the server sent during a session.
+type LexiconStats struct {
+	Learned    int // definitions learned, including accepted duplicates
+	Duplicates int // lines redefining a name or index already seen
+	Rejected   int // lines that couldn't be parsed
+}
+
+// LexiconErrorHooks are called for each lexicon line from the server that
+// can't be learned cleanly, and if the learned lexicon can't be saved to the
+// cache file.
+type LexiconErrorHook func(pconn *Connection, err *LexiconError)
+
+// start learning a fresh session's lexicon.
+func (pconn *Connection) beginLexicon() {
+	pconn.mu.Lock()
+	pconn.lexSeen = make(map[string]*MessageDef)
+	pconn.lexSeenNames = make(map[string]*MessageDef)
+	pconn.lexStats = LexiconStats{}
+	pconn.mu.Unlock()
+}
+
+// learn a lexicon line from the server.
+//
+// Lines that can't be learned are reported to OnLexiconError.  In strict
+// mode they're also returned, ending the connection.  Otherwise bad lines
+// are skipped and duplicates replace the earlier entry.
+func (pconn *Connection) learnLexicon(msg *WireMsg) error {
+	md, err := parseLexicon(msg)
+
+	pconn.mu.Lock()
+	duplicate := false
+	if err == nil && pconn.lexSeen != nil {
+		if old, found := pconn.lexSeen[md.KeyString()]; found && old.HumanName != md.HumanName {
+			err = DuplicateIndexError
+			delete(pconn.lexSeenNames, old.HumanName)
+		} else if old, found := pconn.lexSeenNames[md.HumanName]; found && old.KeyString() != md.KeyString() {
+			err = DuplicateNameError
+			delete(pconn.lexSeen, old.KeyString())
+		}
+		duplicate = err != nil
+	}
+	accept := err == nil || (duplicate && !pconn.StrictLexicon)
+	if accept {
+		pconn.lexStats.Learned++
+		if pconn.lexSeen != nil {
+			pconn.lexSeen[md.KeyString()] = md
+			pconn.lexSeenNames[md.HumanName] = md
+		}
+	}
+	if duplicate {
+		pconn.lexStats.Duplicates++
+	} else if err != nil {
+		pconn.lexStats.Rejected++
+	}
+	pconn.mu.Unlock()
+
+	if accept {
+		pconn.lex.add(md)
+	}
+	if err == nil {
+		return nil
+	}
+	lexErr := &LexiconError{Line: msg.WireString(), Err: err}
+	if hook := pconn.OnLexiconError; hook != nil {
+		hook(pconn, lexErr)
+	}
+	if pconn.StrictLexicon {
+		return lexErr
+	}
+	return nil
+}
+
+// the server has finished sending its lexicon - report differences from the
+// cache, drop anything the server no longer has, and update the cache file
+// if it's out of date.
+func (pconn *Connection) endLexicon() {
+	pconn.mu.Lock()
+	seen := pconn.lexSeen
+	cache := pconn.lexCache
+	cacheVersion := pconn.lexCacheVersion
+	cachePath := pconn.lexCachePath
+	version := pconn.version
+	pconn.lexSeen = nil
+	pconn.lexSeenNames = nil
+	pconn.mu.Unlock()
+	if len(seen) == 0 {
+		// no lexicon this session (eg: a router replaying to a late
+		// joiner) - nothing to compare.
+		return
+	}
+
+	var mismatches []LexiconMismatch
+	for key, live := range seen {
+		cached, found := cache[key]
+		if found && *cached == *live {
+			continue
+		}
+		if cache != nil {
+			mismatches = append(mismatches, LexiconMismatch{CachedVersion: cacheVersion, Version: version, Cached: cached, Live: live})
+		}
+	}
+	for key, cached := range cache {
+		if _, found := seen[key]; found {
+			continue
+		}
+		mismatches = append(mismatches, LexiconMismatch{CachedVersion: cacheVersion, Version: version, Cached: cached})
+		pconn.lex.remove(key)
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].def().less(mismatches[j].def())
+	})
+
+	pconn.mu.Lock()
+	pconn.lexCache = seen
+	pconn.lexCacheVersion = version
+	pconn.mu.Unlock()
+
+	// a preload with no version (eg: from Variables.txt) that matches is
+	// as good as an up to date cache.
+	versionChanged := cacheVersion != "" && cacheVersion != version
+	if cachePath != "" && (cache == nil || len(mismatches) > 0 || versionChanged) {
+		err := pconn.SaveLexicon(cachePath)
+		if hook := pconn.OnLexiconError; err != nil && hook != nil {
+			hook(pconn, &LexiconError{Err: fmt.Errorf("saving lexicon cache: %w", err)})
+		}
+	}
+	if hook := pconn.OnLexiconMismatch; hook != nil {
+		for _, mismatch := range mismatches {
+			hook(pconn, mismatch)
+		}
+	}
+}
+
+// Returns the counts of lexicon lines handled during the current (or most
+// recent) session.
+func (pconn *Connection) LexiconStats() LexiconStats {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.lexStats
+}
@@ -0,0 +1,96 @@
+package psx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// serve a session whose lexicon has a bad mode and a duplicate name.
+func serveBadLexicon(c net.Conn) {
+	for _, line := range []string{
+		"id=3",
+		"version=10.0.0",
+		"Lh402(K)=KeybCduC",
+		"Li242(Z)=UplinkBits",
+		"Li243(Q)=Mystery",
+		"Li244(Z)=UplinkBits",
+		"load1",
+		"load2",
+		"load3",
+		"exit",
+	} {
+		c.Write([]byte(line + "\r\n"))
+	}
+}
+
+func TestLexiconErrors(t *testing.T) {
+	addr := startFakeServer(t, serveBadLexicon)
+	pconn, _ := NewConnection(addr, "test")
+	var lexErrs []*LexiconError
+	pconn.OnLexiconError = func(_ *Connection, err *LexiconError) {
+		lexErrs = append(lexErrs, err)
+	}
+	if err := pconn.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	if len(lexErrs) != 2 {
+		t.Fatalf("Got lexicon errors %v, expected 2", lexErrs)
+	}
+	if !errors.Is(lexErrs[0], UnknownModeError) || lexErrs[0].Line != "Li243(Q)=Mystery" {
+		t.Errorf("Unexpected first error: %s", lexErrs[0])
+	}
+	if !errors.Is(lexErrs[1], DuplicateNameError) {
+		t.Errorf("Unexpected second error: %s", lexErrs[1])
+	}
+	// outside strict mode, the duplicate wins.
+	if key := pconn.NewPair("UplinkBits", "1").GetKey(); key != "Qi244" {
+		t.Errorf("Duplicate name encoded as %q, expected Qi244", key)
+	}
+	want := LexiconStats{Learned: 3, Duplicates: 1, Rejected: 1}
+	if stats := pconn.LexiconStats(); stats != want {
+		t.Errorf("Got stats %+v, expected %+v", stats, want)
+	}
+}
+
+func TestStrictLexicon(t *testing.T) {
+	addr := startFakeServer(t, serveBadLexicon)
+	pconn, _ := NewConnection(addr, "test")
+	pconn.StrictLexicon = true
+	err := pconn.Run(context.Background())
+	var lexErr *LexiconError
+	if !errors.As(err, &lexErr) || !errors.Is(err, UnknownModeError) {
+		t.Fatalf("Run returned %v, expected an unknown mode error", err)
+	}
+	if pconn.State() != StateListenerExited {
+		t.Errorf("Connection ended in state %s", pconn.State())
+	}
+}
+
+func TestStrictLexiconMissingName(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		for _, line := range []string{"id=3", "version=10.0.0", "Li242(Z)=UplinkBits", "Li245(Z)", "load1", "exit"} {
+			c.Write([]byte(line + "\r\n"))
+		}
+	})
+	pconn, _ := NewConnection(addr, "test")
+	pconn.StrictLexicon = true
+	var hooked []*LexiconError
+	pconn.OnLexiconError = func(_ *Connection, err *LexiconError) {
+		hooked = append(hooked, err)
+	}
+	err := pconn.Run(context.Background())
+	var lexErr *LexiconError
+	if !errors.As(err, &lexErr) || !errors.Is(err, LexiconSyntaxError) || lexErr.Line != "Li245(Z)" {
+		t.Fatalf("Run returned %v, expected a syntax error", err)
+	}
+	if len(hooked) != 1 || hooked[0] != lexErr {
+		t.Errorf("OnLexiconError got %v", hooked)
+	}
+	want := LexiconStats{Learned: 1, Rejected: 1}
+	if stats := pconn.LexiconStats(); stats != want {
+		t.Errorf("Got stats %+v, expected %+v", stats, want)
+	}
+}
@@ -0,0 +1,295 @@
+package psx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Unmarshal was given something other than a pointer to a struct, or
+	// Marshal something other than a struct.
+	NotAStructError = errors.New("Value is not a struct (or pointer to one)")
+	// A psx struct tag couldn't be parsed.
+	TagSyntaxError = errors.New("Malformed psx struct tag")
+	// Strict unmarshalling found a field in the value that no struct field
+	// maps.
+	UnmappedFieldError = errors.New("Value has fields not mapped by the struct")
+	// Strict unmarshalling found a value that doesn't fit its struct field
+	// exactly (eg: a fraction for an integer).
+	LossyValueError = errors.New("Value doesn't fit the struct field exactly")
+)
+
+// a single struct field mapped by a psx tag
+type tagField struct {
+	name     string  // Go field name, for error reporting
+	fieldIdx int     // index of the field in the struct
+	valueIdx int     // index of the field in the ; delimited value
+	scale    float64 // wire value = Go value * scale
+	round    bool    // scaled wire values are integral
+	optional bool    // the field may be missing from the value
+}
+
+// the psx tag mapping for a struct type
+type tagStruct struct {
+	fields []tagField
+	width  int // number of value fields covered (highest index + 1)
+}
+
+// tag mappings by struct type
+var tagCache sync.Map
+
+// parse (or fetch from the cache) the psx tags for the struct type t.
+func structTags(t reflect.Type) (*tagStruct, error) {
+	if cached, found := tagCache.Load(t); found {
+		return cached.(*tagStruct), nil
+	}
+	ts := new(tagStruct)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, found := sf.Tag.Lookup("psx")
+		if !found || tag == "-" {
+			continue
+		}
+		tf := tagField{name: sf.Name, fieldIdx: i, scale: 1.0}
+		opts := strings.Split(tag, ",")
+		idx, err := strconv.Atoi(opts[0])
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("%w: field %s: bad index %q", TagSyntaxError, sf.Name, opts[0])
+		}
+		tf.valueIdx = idx
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "rad":
+				// radians on the wire, degrees in the struct
+				tf.scale = math.Pi / 180.0
+			case "milli":
+				tf.scale = 1000.0
+				tf.round = true
+			case "centi":
+				tf.scale = 100.0
+				tf.round = true
+			case "deci":
+				tf.scale = 10.0
+				tf.round = true
+			case "optional":
+				tf.optional = true
+			default:
+				return nil, fmt.Errorf("%w: field %s: unknown option %q", TagSyntaxError, sf.Name, opt)
+			}
+		}
+		switch sf.Type.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return nil, fmt.Errorf("%w: field %s: unsupported type %s", TagSyntaxError, sf.Name, sf.Type)
+		}
+		if !sf.IsExported() {
+			return nil, fmt.Errorf("%w: field %s: not exported", TagSyntaxError, sf.Name)
+		}
+		ts.fields = append(ts.fields, tf)
+		if idx+1 > ts.width {
+			ts.width = idx + 1
+		}
+	}
+	tagCache.Store(t, ts)
+	return ts, nil
+}
+
+// Decode the ; delimited value into the struct pointed to by v, using the
+// psx struct tags on its fields.
+//
+// A tag gives the index of the value field (as used by ValueAtSubIndex),
+// optionally followed by options:
+//
+//	Pitch    float64 `psx:"0,rad"`        // radians on the wire, degrees in Pitch
+//	Altitude float64 `psx:"3,milli"`      // thousandths on the wire
+//	Extra    int     `psx:"7,optional"`   // may be missing from the value
+//
+// The scaling options are rad, milli, centi and deci.  Fields may be strings,
+// bools, integers or floats.
+//
+// Unmarshal is lenient: value fields the struct doesn't map are ignored and
+// scaled values are rounded to fit integer struct fields.  Missing fields are
+// an error unless tagged optional, in which case the struct field is left
+// untouched.
+func (msg *WireMsg) Unmarshal(v interface{}) error {
+	return msg.unmarshal(v, false)
+}
+
+// As Unmarshal, but it is also an error for the value to contain fields the
+// struct doesn't map, or for a value not to fit its struct field exactly.
+func (msg *WireMsg) UnmarshalStrict(v interface{}) error {
+	return msg.unmarshal(v, true)
+}
+
+func (msg *WireMsg) unmarshal(v interface{}, strict bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return NotAStructError
+	}
+	rv = rv.Elem()
+	ts, err := structTags(rv.Type())
+	if err != nil {
+		return err
+	}
+	if !msg.HasValue {
+		return msg.valueError("", rv.Type().String(), NoValueError)
+	}
+	parts := strings.Split(msg.Value, ";")
+	if strict && len(parts) > ts.width {
+		return msg.valueError(msg.Value, rv.Type().String(), UnmappedFieldError)
+	}
+	for _, tf := range ts.fields {
+		want := tf.name + " (field " + strconv.Itoa(tf.valueIdx) + ")"
+		if tf.valueIdx >= len(parts) {
+			if tf.optional {
+				continue
+			}
+			return msg.valueError(msg.Value, want, FieldRangeError)
+		}
+		err = decodeField(rv.Field(tf.fieldIdx), parts[tf.valueIdx], tf, strict)
+		if err != nil {
+			return msg.valueError(parts[tf.valueIdx], want, err)
+		}
+	}
+	return nil
+}
+
+// unwrap strconv errors down to their cause
+func numError(err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		return numErr.Err
+	}
+	return err
+}
+
+// decode a single value field into the struct field fv
+func decodeField(fv reflect.Value, raw string, tf tagField, strict bool) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+		return nil
+	case reflect.Bool:
+		val, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetBool(val != 0)
+		return nil
+	}
+
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return numError(err)
+	}
+	val /= tf.scale
+
+	switch fv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		fv.SetFloat(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rounded := math.Round(val)
+		if strict && rounded != val {
+			return LossyValueError
+		}
+		if fv.OverflowInt(int64(rounded)) {
+			return strconv.ErrRange
+		}
+		fv.SetInt(int64(rounded))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rounded := math.Round(val)
+		if strict && rounded != val {
+			return LossyValueError
+		}
+		if rounded < 0 || fv.OverflowUint(uint64(rounded)) {
+			return strconv.ErrRange
+		}
+		fv.SetUint(uint64(rounded))
+	}
+	return nil
+}
+
+// encode the struct field fv as a single value field
+func encodeField(fv reflect.Value, tf tagField) string {
+	var val float64
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String()
+	case reflect.Bool:
+		if fv.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if tf.scale == 1.0 {
+			return strconv.FormatInt(fv.Int(), 10)
+		}
+		val = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if tf.scale == 1.0 {
+			return strconv.FormatUint(fv.Uint(), 10)
+		}
+		val = float64(fv.Uint())
+	case reflect.Float32, reflect.Float64:
+		val = fv.Float()
+	}
+	val *= tf.scale
+	if tf.round {
+		val = math.Round(val)
+	}
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
+
+// Encode the struct (or pointer to struct) v as a ; delimited value using
+// its psx struct tags - the reverse of WireMsg.Unmarshal.
+//
+// Value fields the struct doesn't map are left empty.  Trailing optional
+// fields holding their zero value are left off entirely.
+func Marshal(v interface{}) (string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return "", NotAStructError
+	}
+	ts, err := structTags(rv.Type())
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, ts.width)
+	// which value fields may be dropped from the end
+	droppable := make([]bool, ts.width)
+	for i := range droppable {
+		droppable[i] = true
+	}
+	for _, tf := range ts.fields {
+		fv := rv.Field(tf.fieldIdx)
+		parts[tf.valueIdx] = encodeField(fv, tf)
+		if !tf.optional || !fv.IsZero() {
+			droppable[tf.valueIdx] = false
+		}
+	}
+	width := ts.width
+	for width > 0 && droppable[width-1] {
+		width--
+	}
+	return strings.Join(parts[:width], ";"), nil
+}
+
+// Encode the struct v with Marshal and set it as the message value.
+func (msg *WireMsg) SetMarshal(v interface{}) error {
+	val, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg.SetString(val)
+	return nil
+}
@@ -0,0 +1,96 @@
+package psx
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+type testPosition struct {
+	Pitch     float64 `psx:"0,rad"`
+	Bank      float64 `psx:"1,rad"`
+	Heading   float64 `psx:"2,rad"`
+	Altitude  float64 `psx:"3,milli"`
+	Tas       int     `psx:"4,milli"`
+	Latitude  float64 `psx:"5"`
+	Longitude float64 `psx:"6"`
+	Extra     string  `psx:"7,optional"`
+	ignored   int
+}
+
+func TestUnmarshal(t *testing.T) {
+	msg := parseMsg(nil, "Qs121=0.1;0;3.141592653589793;35000500;250400;0.5;-1.5")
+	var pos testPosition
+	pos.Extra = "untouched"
+	if err := msg.Unmarshal(&pos); err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+	if math.Abs(pos.Heading-180.0) > 1e-9 || math.Abs(pos.Pitch-5.729578) > 1e-6 {
+		t.Errorf("Bad angle conversion: %+v", pos)
+	}
+	if pos.Altitude != 35000.5 || pos.Tas != 250 {
+		t.Errorf("Bad milli conversion: %+v", pos)
+	}
+	if pos.Latitude != 0.5 || pos.Longitude != -1.5 {
+		t.Errorf("Bad unscaled values: %+v", pos)
+	}
+	if pos.Extra != "untouched" {
+		t.Errorf("Missing optional field was modified: %q", pos.Extra)
+	}
+
+	// strict mode refuses to round the TAS.
+	if err := msg.UnmarshalStrict(&pos); !errors.Is(err, LossyValueError) {
+		t.Errorf("UnmarshalStrict returned %v for a lossy value", err)
+	}
+	msg = parseMsg(nil, "Qs121=0;0;0;0;0;0;0;x;y")
+	if err := msg.Unmarshal(&pos); err != nil {
+		t.Errorf("Lenient Unmarshal rejected extra fields: %s", err)
+	}
+	if err := msg.UnmarshalStrict(&pos); !errors.Is(err, UnmappedFieldError) {
+		t.Errorf("UnmarshalStrict returned %v for extra fields", err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var pos testPosition
+	msg := parseMsg(nil, "Qs121=0;0;0")
+	if err := msg.Unmarshal(&pos); !errors.Is(err, FieldRangeError) {
+		t.Errorf("Short value returned %v", err)
+	}
+	msg = parseMsg(nil, "Qs121=0;zero;0;0;0;0;0")
+	var valueErr *ValueError
+	if err := msg.Unmarshal(&pos); !errors.As(err, &valueErr) || valueErr.Value != "zero" {
+		t.Errorf("Malformed value returned %v", err)
+	}
+	if err := msg.Unmarshal(pos); !errors.Is(err, NotAStructError) {
+		t.Errorf("Non-pointer returned %v", err)
+	}
+	var bad struct {
+		Field float64 `psx:"1,furlongs"`
+	}
+	if err := msg.Unmarshal(&bad); !errors.Is(err, TagSyntaxError) {
+		t.Errorf("Bad tag returned %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	pos := testPosition{Heading: 180, Altitude: 35000.5, Tas: 250, Latitude: 0.5, Longitude: -1.5}
+	val, err := Marshal(pos)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	if val != "0;0;3.141592653589793;35000500;250000;0.5;-1.5" {
+		t.Errorf("Unexpected encoding %q", val)
+	}
+	pos.Extra = "x"
+	if val, _ = Marshal(&pos); val != "0;0;3.141592653589793;35000500;250000;0.5;-1.5;x" {
+		t.Errorf("Unexpected encoding with optional field %q", val)
+	}
+
+	var roundTrip testPosition
+	msg := parseMsg(nil, "Qs121")
+	msg.SetMarshal(pos)
+	if err := msg.Unmarshal(&roundTrip); err != nil || roundTrip != pos {
+		t.Errorf("Round trip produced %+v, %v", roundTrip, err)
+	}
+}
@@ -6,10 +6,13 @@ package psx
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -20,17 +23,42 @@ var (
 	ConnectionBusyError = errors.New("Connection is still busy and unable to reconnect")
 )
 
+// ConnState is the lifecycle state of a Connection.
+type ConnState int
+
 const (
-	connPhaseDisconnected = iota
-	connPhaseNew
-	connPhaseLoad1
-	connPhaseLoad2
-	connPhaseRunning
-	connPhaseFailed
-	connPhaseEnded
-	connPhaseListenerExited
+	StateDisconnected   ConnState = iota // never connected, or Run was cancelled
+	StateNew                             // connected, learning the lexicon
+	StateLoad1                           // server is loading a situation (load1 received)
+	StateLoad2                           // load2 received
+	StateRunning                         // situation loaded (load3 received)
+	StateFailed                          // connection failed with an error
+	StateEnded                           // server sent exit
+	StateListenerExited                  // listener has shut down - ready to reconnect
 )
 
+var connStateNames = []string{
+	"Disconnected",
+	"New",
+	"Load1",
+	"Load2",
+	"Running",
+	"Failed",
+	"Ended",
+	"ListenerExited",
+}
+
+func (state ConnState) String() string {
+	if state < 0 || int(state) >= len(connStateNames) {
+		return "ConnState(" + strconv.Itoa(int(state)) + ")"
+	}
+	return connStateNames[state]
+}
+
+// StateChangeHooks are called whenever the Connection moves from one
+// ConnState to another.
+type StateChangeHook func(oldState, newState ConnState)
+
 // MessageHooks are used for all callbacks from Connection's listener.
 //
 // The Connection is passed through pconn, and the message that triggered the
@@ -45,7 +73,8 @@ type MessageHook func(pconn *Connection, msg *WireMsg)
 // Server, ClientName and InstanceName can all be changed after initialisation
 // but will not be reported to the server whilst the connection is active.
 //
-//
+// All methods are safe to call from any goroutine.  The exported fields are
+// configuration and should be set up before the connection is started.
 type Connection struct {
 	// Hostname & Port to connect to
 	Server string
@@ -57,38 +86,104 @@ type Connection struct {
 	// Callback Hooks.
 	//
 	// The key is the (decoded, if necessary) attribute.
-	Hooks map[string]MessageHook
+	//
+	// The map may only be modified directly before the connection is
+	// started - use SetHook or AddHook to change hooks once the Listener is
+	// running.
+	Hooks   map[string]MessageHook
+	hooksMu sync.RWMutex
+	// hooks registered with AddHook, by key, and the matching and
+	// catch-all hooks.  The slices are replaced, never modified, so callHook
+	// can use them without holding hooksMu.
+	hookList      map[string][]hookEntry
+	hookKeys      map[HookID]string
+	matchHooks    []hookEntry
+	catchAllHooks []hookEntry
+	lastHookId    HookID
+
+	// Called for every message received from the server, before the
+	// keyed Hooks.  Use SetMonitorHook to change it once the connection is
+	// started.
+	MonitorHook MessageHook
+
+	// Reconnection pacing used by RunWithReconnect.
+	Backoff Backoff
+	// Called by RunWithReconnect before waiting to reconnect.
+	OnReconnect ReconnectHook
+	// Called by Run whenever a new connection is established.
+	OnConnect ConnectHook
+	// Called for each difference between a preloaded lexicon and the
+	// server's.
+	OnLexiconMismatch LexiconMismatchHook
+	// If set, a lexicon line with bad syntax, an unknown mode or a
+	// duplicate name or index ends the connection with a *LexiconError.
+	// Otherwise such lines are reported to OnLexiconError and skipped (or,
+	// for duplicates, replace the earlier entry).
+	StrictLexicon bool
+	// Called for each lexicon line that can't be learned cleanly, and when
+	// the lexicon cache can't be saved.
+	OnLexiconError LexiconErrorHook
+
+	// Called with every raw line received from or sent to the server -
+	// see the capture package for a recorder.  Use SetWireTap to change it
+	// once the connection is started.
+	WireTap WireTapHook
 
 	// read-only information from the server
 	myId    int    // ID the server/router assigned us
 	version string // Version info as provided by the server/router
 	// connection phase
-	connPhase int // One of the ConnPhase* constants - defines what the current connection state is
+	connPhase      ConnState // defines what the current connection state is
+	stateListeners []StateChangeHook
+	// set once the current connection has seen load3
+	sessionLoaded bool
 
 	// notification/subscription list for SwitchPSX
 	notify []string
+	// held whilst building and sending a notify message so updates go out
+	// in order
+	notifyMu sync.Mutex
+
+	// demand mode variables requested, with their request counts
+	demands map[string]int
+
+	// the preloaded (or previously learned) lexicon to check the server's
+	// against, the server version it came from, and the file to keep it in.
+	lexCache        map[string]*MessageDef
+	lexCacheVersion string
+	lexCachePath    string
+	// the definitions the server has sent this session, by key and name,
+	// and the running counts
+	lexSeen      map[string]*MessageDef
+	lexSeenNames map[string]*MessageDef
+	lexStats     LexiconStats
+
+	// state store, if enabled
+	store *Store
 
 	// internal bits
-	conn *net.TCPConn
-	lex  *lexicon
+	conn       *net.TCPConn
+	connSerial uint64 // counts the connections established, identifying conn
+	dialing    bool   // set whilst ConnectContext is establishing conn
+	listening  bool   // set whilst a Listener (or Run) is reading conn
+	lex        *Lexicon
+
+	// mu guards the server information, connection state, notify and demand
+	// lists, conn, and MonitorHook and WireTap once the connection is
+	// started.  writeMu serialises writes so concurrent senders can't interleave.
+	mu      sync.Mutex
+	writeMu sync.Mutex
 
 	bufReader *bufio.Reader
 }
 
-// invoke the callback with name hookName.
-func (pconn *Connection) callHook(hookName string, msg *WireMsg) {
-	callback, found := pconn.Hooks[hookName]
-	if found && callback != nil {
-		callback(pconn, msg)
-	}
-}
-
 func NewConnection(server, myName string) (pconn *Connection, err error) {
 	pconn = new(Connection)
-	pconn.lex = newLexicon()
+	pconn.lex = NewLexicon()
 	pconn.notify = make([]string, 0)
-	pconn.connPhase = connPhaseDisconnected
+	pconn.connPhase = StateDisconnected
 	pconn.Hooks = make(map[string]MessageHook, 0)
+	pconn.Backoff = DefaultBackoff
 
 	pconn.Server = server
 	pconn.ClientName = myName
@@ -98,14 +193,86 @@ func NewConnection(server, myName string) (pconn *Connection, err error) {
 
 // Returns the ID as assigned by the server/router
 func (pconn *Connection) Id() int {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
 	return pconn.myId
 }
 
 // Returns the Software Version as reported by the server
 func (pconn *Connection) Version() string {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
 	return pconn.version
 }
 
+// Returns the current connection state
+func (pconn *Connection) State() ConnState {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.connPhase
+}
+
+// Register fn to be called on every connection state transition.
+//
+// Transitions are driven by connecting, the id, load1, load2, load3 and exit
+// messages, and socket errors.  fn is called from the goroutine causing the
+// transition - usually the Listener.
+func (pconn *Connection) OnStateChange(fn StateChangeHook) {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	pconn.stateListeners = append(pconn.stateListeners, fn)
+}
+
+// move to newState, notifying the state listeners if it's a change.
+func (pconn *Connection) setState(newState ConnState) {
+	pconn.mu.Lock()
+	oldState := pconn.connPhase
+	if oldState == newState {
+		pconn.mu.Unlock()
+		return
+	}
+	pconn.connPhase = newState
+	listeners := pconn.stateListeners
+	pconn.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(oldState, newState)
+	}
+}
+
+// returns true if the current connection has made it to load3
+func (pconn *Connection) loaded() bool {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.sessionLoaded
+}
+
+// returns the live socket, or nil if we're not connected
+func (pconn *Connection) currentConn() *net.TCPConn {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.conn
+}
+
+// WireDirection says which way a raw line travelled.
+type WireDirection int
+
+const (
+	WireReceived WireDirection = iota // from the server
+	WireSent                          // to the server
+)
+
+// WireTapHooks observe raw lines as they are received from, or sent to, the
+// server.  serial identifies the TCP connection the line travelled on - it
+// increases with each new connection.
+type WireTapHook func(pconn *Connection, serial uint64, dir WireDirection, line string)
+
+// Returns the connection's lexicon, as preloaded or learned from the
+// server.
+func (pconn *Connection) Lexicon() *Lexicon {
+	return pconn.lex
+}
+
 /* return a new WireMsg linked to the Connection's Lexicon */
 func (pconn *Connection) NewWireMsg() *WireMsg {
 	return newWireMsg(pconn.lex)
@@ -113,38 +280,98 @@ func (pconn *Connection) NewWireMsg() *WireMsg {
 
 // Connect to the server.
 func (pconn *Connection) Connect() (err error) {
+	return pconn.ConnectContext(context.Background())
+}
+
+// Connect to the server, abandoning the attempt if ctx is cancelled or
+// expires before the connection is established.
+func (pconn *Connection) ConnectContext(ctx context.Context) (err error) {
+	pconn.mu.Lock()
 	if nil != pconn.conn {
+		pconn.mu.Unlock()
 		return
 	}
-	if pconn.connPhase != connPhaseListenerExited && pconn.connPhase != connPhaseDisconnected {
+	if pconn.dialing || (pconn.connPhase != StateListenerExited && pconn.connPhase != StateDisconnected) {
+		pconn.mu.Unlock()
 		return ConnectionBusyError
 	}
+	pconn.dialing = true
+	pconn.sessionLoaded = false
+	pconn.mu.Unlock()
 
-	addr, err := net.ResolveTCPAddr("tcp", pconn.Server)
-	if err != nil {
-		return err
-	}
-	pconn.conn, err = net.DialTCP("tcp", nil, addr)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", pconn.Server)
+
+	pconn.mu.Lock()
+	pconn.dialing = false
 	if err != nil {
-		pconn.conn = nil
+		pconn.mu.Unlock()
 		return err
 	}
-	pconn.connPhase = connPhaseNew
+	pconn.conn = conn.(*net.TCPConn)
+	pconn.connSerial++
 	// disable nagle explicitly - it may be the defined default, but we really want it off.
 	pconn.conn.SetNoDelay(true)
+	pconn.mu.Unlock()
 
+	pconn.setState(StateNew)
 	return nil
 }
 
+// Connect to the server and run the Listener until the connection ends.
+//
+// Run returns nil if the server ended the session with an exit, ctx.Err() if
+// the context was cancelled, or otherwise the error that terminated the
+// connection.  Cancelling ctx disconnects cleanly from the server, leaving
+// the Connection StateDisconnected rather than StateFailed.
+//
+// Run returns ConnectionBusyError if a Listener is already running.
+func (pconn *Connection) Run(ctx context.Context) error {
+	pconn.mu.Lock()
+	listening := pconn.listening
+	pconn.mu.Unlock()
+	if listening {
+		return ConnectionBusyError
+	}
+	err := pconn.ConnectContext(ctx)
+	if err != nil {
+		return err
+	}
+	if pconn.OnConnect != nil {
+		pconn.OnConnect(pconn)
+	}
+
+	// watch the context and unblock the reader if it's cancelled.
+	conn := pconn.currentConn()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err = pconn.listen(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // Disconnect from the server.
 func (pconn *Connection) Disconnect() {
-	if nil == pconn.conn {
+	pconn.mu.Lock()
+	conn := pconn.conn
+	pconn.conn = nil
+	pconn.mu.Unlock()
+	if nil == conn {
 		return
 	}
 	// close the reader so we can shut down propertly.
-	pconn.sendLine("exit")
-	pconn.conn.Close()
-	pconn.conn = nil
+	pconn.writeLine(conn, "exit")
+	conn.Close()
 }
 
 // send our identity (name)
@@ -158,33 +385,69 @@ func (pconn *Connection) sendName() {
 }
 
 // send our notify message.
-func (pconn *Connection) sendNotify() {
+//
+// An empty list is only sent if force is set - an empty notify asks the
+// Router/SwitchPSX for everything, which is already the default for a new
+// connection.
+func (pconn *Connection) sendNotify(force bool) error {
+	pconn.notifyMu.Lock()
+	defer pconn.notifyMu.Unlock()
+
+	pconn.mu.Lock()
+	notify := pconn.notify
+	pconn.mu.Unlock()
+
 	var notifyList []string = make([]string, 0)
-	for _, v := range pconn.notify {
+	for _, v := range notify {
 		keyName := pconn.lex.keyFor(v)
 		if keyName != "" {
 			notifyList = append(notifyList, pconn.lex.keyFor(v))
 		}
 	}
-	if len(notifyList) > 0 {
-		pconn.SendMsg(pconn.NewPair("notify", strings.Join(notifyList, ";")))
+	if len(notifyList) > 0 || force {
+		return pconn.SendMsg(pconn.NewPair("notify", strings.Join(notifyList, ";")))
+	}
+	return nil
+}
+
+// returns true once the lexicon has been learned on the current
+// connection, and messages to the server can be encoded.
+func (pconn *Connection) lexiconReady() bool {
+	switch pconn.State() {
+	case StateLoad1, StateLoad2, StateRunning:
+		return true
 	}
+	return false
 }
 
 func (pconn *Connection) SendMsg(msg *WireMsg) (err error) {
-	return pconn.sendLine(msg.WireString())
+	err = pconn.sendLine(msg.WireString())
+	if err == nil {
+		if store := pconn.Store(); store != nil {
+			store.update(msg)
+		}
+	}
+	return err
 }
 
 func (pconn *Connection) sendLine(line string) (err error) {
-	if nil == pconn.conn {
+	conn := pconn.currentConn()
+	if nil == conn {
 		return NotConnectedError
 	}
+	return pconn.writeLine(conn, line)
+}
+
+// write a single line to conn.  Holds writeMu so the line goes out in one piece.
+func (pconn *Connection) writeLine(conn *net.TCPConn, line string) (err error) {
 	var msg []byte
 
 	msg = []byte(line)
 	// append a CR+LF pair
 	msg = append(msg, 13, 10)
-	wlen, err := pconn.conn.Write(msg)
+	pconn.writeMu.Lock()
+	defer pconn.writeMu.Unlock()
+	wlen, err := conn.Write(msg)
 	if err != nil {
 		return err
 	}
@@ -192,17 +455,78 @@ func (pconn *Connection) sendLine(line string) (err error) {
 		// well crap - a short write without cause - shouldn't happen.  panic.
 		panic("short write")
 	}
+	if tap := pconn.wireTap(); tap != nil {
+		tap(pconn, pconn.serial(), WireSent, line)
+	}
 	return nil
 }
 
+// returns the serial number of the current (or last) connection.
+func (pconn *Connection) serial() uint64 {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.connSerial
+}
+
+// Replace the WireTap.  Unlike setting WireTap directly, this is safe whilst
+// the connection is running.
+func (pconn *Connection) SetWireTap(tap WireTapHook) {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	pconn.WireTap = tap
+}
+
+func (pconn *Connection) wireTap() WireTapHook {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.WireTap
+}
+
+// Replace the MonitorHook.  Unlike setting MonitorHook directly, this is
+// safe whilst the connection is running.
+func (pconn *Connection) SetMonitorHook(hook MessageHook) {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	pconn.MonitorHook = hook
+}
+
+func (pconn *Connection) monitorHook() MessageHook {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.MonitorHook
+}
+
 // The Listner needs to be started AFTER Connect() has been invoked.
 //
 // It can be started in it's own goroutine, or in the current one depending on
 // requirements, but is generally intended to run in its own goroutine.
+//
+// Use Run instead if you need to know why the connection ended.
 func (pconn *Connection) Listener() {
-	var err error = nil
+	pconn.listen(context.Background())
+}
+
+// the read loop behind Listener and Run.  returns nil if the server sent exit,
+// or the error that terminated the connection otherwise.  An error caused by
+// ctx being cancelled isn't a failure - the connection is simply
+// disconnected.
+func (pconn *Connection) listen(ctx context.Context) (err error) {
+	pconn.mu.Lock()
+	conn := pconn.conn
+	if nil == conn {
+		pconn.mu.Unlock()
+		return NotConnectedError
+	}
+	if pconn.listening {
+		pconn.mu.Unlock()
+		return ConnectionBusyError
+	}
+	pconn.listening = true
+	pconn.mu.Unlock()
+
+	serial := pconn.serial()
 	running := true
-	pconn.bufReader = bufio.NewReader(pconn.conn)
+	pconn.bufReader = bufio.NewReader(conn)
 	for running {
 		var rawLine []byte = make([]byte, 0)
 
@@ -221,47 +545,86 @@ func (pconn *Connection) Listener() {
 			break
 		}
 
+		if tap := pconn.wireTap(); tap != nil {
+			tap(pconn, serial, WireReceived, string(rawLine))
+		}
+
 		// fast parse the message
 		msg := parseMsg(pconn.lex, string(rawLine))
 
 		// all hard-coded reponses.
 		switch msg.GetKey() {
 		case "id":
-			pconn.myId, _ = strconv.Atoi(msg.Value)
+			// an id always marks the start of a fresh session.
+			pconn.setState(StateNew)
+			pconn.beginLexicon()
+			id, _ := strconv.Atoi(msg.Value)
+			pconn.mu.Lock()
+			pconn.myId = id
+			pconn.mu.Unlock()
 			pconn.sendName()
 		case "version":
+			pconn.mu.Lock()
 			pconn.version = msg.Value
+			pconn.mu.Unlock()
 		case "load1":
 			// if we were a new connection, we were unable
-			// to send notify requests until now - subscribe to our
+			// to send notify or demand requests until now - subscribe to our
 			// desired messages.
-			if pconn.connPhase == connPhaseNew {
-				pconn.sendNotify()
+			//
+			// move to load1 first so that anything subscribed or
+			// demanded concurrently is sent by one side or the other.
+			wasNew := pconn.State() == StateNew
+			pconn.setState(StateLoad1)
+			if wasNew {
+				pconn.endLexicon()
+				pconn.sendNotify(false)
+				pconn.sendDemands()
 			}
-			pconn.connPhase = connPhaseLoad1
 		case "load2":
-			pconn.connPhase = connPhaseLoad2
+			pconn.setState(StateLoad2)
 		case "load3":
-			pconn.connPhase = connPhaseRunning
+			pconn.mu.Lock()
+			pconn.sessionLoaded = true
+			pconn.mu.Unlock()
+			pconn.setState(StateRunning)
 		case "exit":
-			pconn.connPhase = connPhaseEnded
+			pconn.setState(StateEnded)
+			running = false
 		default:
-			if !msg.HasValue {
-				break
-			}
-			if pconn.connPhase == connPhaseNew && msg.GetKey()[0] == 'L' {
-				pconn.lex.parse(msg)
+			// lexicon lines missing their name still go to learnLexicon,
+			// to be rejected.
+			if pconn.State() == StateNew && strings.HasPrefix(msg.GetKey(), "L") {
+				if err = pconn.learnLexicon(msg); err != nil {
+					running = false
+					continue
+				}
 			}
 		}
+		if store := pconn.Store(); store != nil {
+			store.update(msg)
+		}
 		// once we've completed all of our integrated responses, we
 		// can attempt to use the callback hooks.
+		if hook := pconn.monitorHook(); hook != nil {
+			hook(pconn, msg)
+		}
 		pconn.callHook(msg.GetDecodedKey(), msg)
 	}
-	if err != nil {
-		pconn.connPhase = connPhaseFailed
+	cancelled := err != nil && ctx.Err() != nil
+	if err != nil && !cancelled {
+		pconn.setState(StateFailed)
 	}
 	pconn.Disconnect()
-	pconn.connPhase = connPhaseListenerExited
+	pconn.mu.Lock()
+	pconn.listening = false
+	pconn.mu.Unlock()
+	if cancelled {
+		pconn.setState(StateDisconnected)
+	} else {
+		pconn.setState(StateListenerExited)
+	}
+	return err
 }
 
 // Initialise a message given the human readable key/value pair
@@ -273,12 +636,57 @@ func (pconn *Connection) NewPair(humanKey, value string) (msg *WireMsg) {
 	return msg
 }
 
-// Add the named Q variable to the filter
-func (pconn *Connection) Subscribe(humanKey string) {
+// Add the named Q variable to the filter.
+//
+// If the connection is live, the updated filter is sent to the
+// Router/SwitchPSX immediately.  Otherwise it is sent once the lexicon has
+// been learned.
+func (pconn *Connection) Subscribe(humanKey string) error {
+	pconn.mu.Lock()
 	for _, k := range pconn.notify {
 		if k == humanKey {
-			return
+			pconn.mu.Unlock()
+			return nil
 		}
 	}
-	pconn.notify = append(pconn.notify, humanKey)
+	notify := make([]string, len(pconn.notify), len(pconn.notify)+1)
+	copy(notify, pconn.notify)
+	pconn.notify = append(notify, humanKey)
+	pconn.mu.Unlock()
+
+	if pconn.lexiconReady() {
+		return pconn.sendNotify(true)
+	}
+	return nil
+}
+
+// Remove the named Q variable from the filter.
+//
+// If the connection is live, the updated filter is sent to the
+// Router/SwitchPSX immediately.  Removing the last subscription sends an
+// empty notify, which the router treats as no filter at all, returning the
+// connection to receiving everything.
+func (pconn *Connection) Unsubscribe(humanKey string) error {
+	pconn.mu.Lock()
+	notify := make([]string, 0, len(pconn.notify))
+	for _, k := range pconn.notify {
+		if k != humanKey {
+			notify = append(notify, k)
+		}
+	}
+	changed := len(notify) != len(pconn.notify)
+	pconn.notify = notify
+	pconn.mu.Unlock()
+
+	if changed && pconn.lexiconReady() {
+		return pconn.sendNotify(true)
+	}
+	return nil
+}
+
+// Returns the names of the Q variables currently in the filter.
+func (pconn *Connection) Subscriptions() []string {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return append([]string(nil), pconn.notify...)
 }
@@ -0,0 +1,314 @@
+package psx
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a minimal PSX main server for exercising the Connection.  serve is invoked
+// for each accepted client with the client socket.
+func startFakeServer(t *testing.T, serve func(c net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// send the standard connection preamble to a client
+func sendPreamble(c net.Conn) {
+	for _, line := range []string{
+		"id=3",
+		"version=10.0.0",
+		"Lh402(K)=KeybCduC",
+		"Li242(Z)=UplinkBits",
+		"load1",
+		"load2",
+		"load3",
+	} {
+		c.Write([]byte(line + "\r\n"))
+	}
+}
+
+func TestRunServerExit(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("Qi242=42\r\nexit\r\n"))
+		// drain until the client goes away.
+		io.Copy(io.Discard, c)
+	})
+	pconn, _ := NewConnection(addr, "test")
+	var got string
+	pconn.Hooks["UplinkBits"] = func(_ *Connection, msg *WireMsg) {
+		got = msg.Value
+	}
+
+	err := pconn.Run(context.Background())
+	if err != nil {
+		t.Errorf("Run returned unexpected error: %s", err)
+	}
+	if got != "42" {
+		t.Errorf("Hook got unexpected value: %q", got)
+	}
+	if pconn.Id() != 3 {
+		t.Errorf("Unexpected Id: %d", pconn.Id())
+	}
+}
+
+func TestStateChanges(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("exit\r\n"))
+		io.Copy(io.Discard, c)
+	})
+	pconn, _ := NewConnection(addr, "test")
+	if pconn.State() != StateDisconnected {
+		t.Errorf("New connection in unexpected state %s", pconn.State())
+	}
+	var transitions []string
+	pconn.OnStateChange(func(oldState, newState ConnState) {
+		transitions = append(transitions, oldState.String()+">"+newState.String())
+	})
+	pconn.Run(context.Background())
+
+	expected := "Disconnected>New New>Load1 Load1>Load2 Load2>Running Running>Ended Ended>ListenerExited"
+	if got := strings.Join(transitions, " "); got != expected {
+		t.Errorf("Unexpected transitions:\n got: %s\nwant: %s", got, expected)
+	}
+	if pconn.State() != StateListenerExited {
+		t.Errorf("Finished connection in unexpected state %s", pconn.State())
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	gotExit := make(chan bool, 1)
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			if scanner.Text() == "exit" {
+				gotExit <- true
+				return
+			}
+		}
+		gotExit <- false
+	})
+	pconn, _ := NewConnection(addr, "test")
+	ctx, cancel := context.WithCancel(context.Background())
+	pconn.Hooks["load3"] = func(_ *Connection, _ *WireMsg) {
+		// a second Run mustn't start another reader on the same socket.
+		if err := pconn.Run(ctx); err != ConnectionBusyError {
+			t.Errorf("Run whilst running returned %v", err)
+		}
+		cancel()
+	}
+	var states []string
+	pconn.OnStateChange(func(_, newState ConnState) {
+		states = append(states, newState.String())
+	})
+
+	err := pconn.Run(ctx)
+	if err != context.Canceled {
+		t.Errorf("Run returned unexpected error: %v", err)
+	}
+	// cancelling is a clean shutdown, not a failure.
+	if pconn.State() != StateDisconnected {
+		t.Errorf("Connection finished in state %s", pconn.State())
+	}
+	if got := strings.Join(states, ","); got != "New,Load1,Load2,Running,Disconnected" {
+		t.Errorf("Unexpected state changes %s", got)
+	}
+	select {
+	case sawExit := <-gotExit:
+		if !sawExit {
+			t.Error("Server didn't receive exit from client")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for server to see disconnect")
+	}
+}
+
+func TestRunServerDrop(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+	})
+	pconn, _ := NewConnection(addr, "test")
+	err := pconn.Run(context.Background())
+	if err == nil {
+		t.Error("Run didn't report the dropped connection")
+	}
+}
+
+// exercise the Connection from many goroutines at once whilst the Listener is
+// busy.  Most useful under the race detector.
+func TestConcurrentUse(t *testing.T) {
+	const senders = 8
+	const perSender = 100
+
+	var mu sync.Mutex
+	var received []string
+	serverDone := make(chan bool)
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		stop := make(chan bool)
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, err := c.Write([]byte("Qi242=" + strconv.Itoa(i) + "\r\n"))
+				if err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			if scanner.Text() == "done" {
+				// everything the senders wrote is ahead of this.
+				close(serverDone)
+				break
+			}
+			mu.Lock()
+			received = append(received, scanner.Text())
+			mu.Unlock()
+		}
+		close(stop)
+		io.Copy(io.Discard, c)
+	})
+
+	pconn, _ := NewConnection(addr, "test")
+	running := make(chan bool)
+	pconn.OnStateChange(func(_, newState ConnState) {
+		if newState == StateRunning {
+			close(running)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan error)
+	go func() {
+		runDone <- pconn.Run(ctx)
+	}()
+	<-running
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				pconn.SendMsg(pconn.NewPair("UplinkBits", strconv.Itoa(i*perSender+j)))
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < perSender; j++ {
+			pconn.Subscribe("Var" + strconv.Itoa(j))
+			pconn.SetHook("UplinkBits", func(_ *Connection, _ *WireMsg) {})
+			pconn.SetHook("KeybCduC", nil)
+			pconn.Id()
+			pconn.Version()
+			pconn.State()
+		}
+	}()
+	wg.Wait()
+	pconn.sendLine("done")
+	<-serverDone
+	cancel()
+	if err := <-runDone; err != context.Canceled {
+		t.Errorf("Run returned unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	seen := make(map[string]bool)
+	for _, line := range received {
+		if !strings.HasPrefix(line, "Qi242=") {
+			continue
+		}
+		if _, err := strconv.Atoi(line[len("Qi242="):]); err != nil {
+			t.Errorf("Received mangled line: %q", line)
+		}
+		seen[line] = true
+	}
+	if len(seen) != senders*perSender {
+		t.Errorf("Expected %d distinct messages, server saw %d", senders*perSender, len(seen))
+	}
+}
+
+func TestLiveSubscribe(t *testing.T) {
+	notifies := make(chan string, 10)
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "notify=") {
+				notifies <- scanner.Text()
+			}
+		}
+	})
+	expectNotify := func(want string) {
+		t.Helper()
+		select {
+		case got := <-notifies:
+			if got != want {
+				t.Errorf("Got %q, expected %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+
+	pconn, _ := NewConnection(addr, "test")
+	pconn.Subscribe("UplinkBits")
+	running := make(chan bool)
+	pconn.OnStateChange(func(_, newState ConnState) {
+		if newState == StateRunning {
+			close(running)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pconn.Run(ctx)
+	<-running
+
+	expectNotify("notify=Qi242")
+	pconn.Subscribe("KeybCduC")
+	expectNotify("notify=Qi242;Qh402")
+	pconn.Subscribe("KeybCduC")
+	pconn.Unsubscribe("UplinkBits")
+	expectNotify("notify=Qh402")
+	if subs := pconn.Subscriptions(); len(subs) != 1 || subs[0] != "KeybCduC" {
+		t.Errorf("Unexpected subscriptions: %v", subs)
+	}
+	pconn.Unsubscribe("KeybCduC")
+	expectNotify("notify=")
+	if subs := pconn.Subscriptions(); len(subs) != 0 {
+		t.Errorf("Unexpected subscriptions: %v", subs)
+	}
+}
@@ -0,0 +1,344 @@
+// Provides a scriptable fake PSX main server for testing code that uses
+// psx.Connection without a running simulator.
+//
+// The Server listens on a local port, greets every client with the usual
+// id/version/lexicon/load handshake and records everything the clients send
+// back, so tests can drive updates and then inspect the client's responses.
+package psxtest
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	// Returned by the Wait methods when the condition isn't met in time.
+	TimeoutError = errors.New("psxtest: timed out")
+	// Returned when a human name isn't in the Server's lexicon.
+	UnknownNameError = errors.New("psxtest: name not in lexicon")
+)
+
+// Server is a fake PSX main server.
+//
+// Use NewServer to start one.  All methods are safe to call from any
+// goroutine.
+type Server struct {
+	// Version string reported to clients
+	Version string
+	// If set, new clients are sent load1/load2/load3 immediately after the
+	// lexicon.  Otherwise call Load to send them.
+	AutoLoad bool
+
+	listener net.Listener
+
+	mu      sync.Mutex
+	lexicon []psx.MessageDef
+	byName  map[string]*psx.MessageDef
+	state   map[string]string // current value, by wire key
+	order   []string          // wire keys in the order they were first set
+	clients []*Client
+	nextId  int
+	changed chan struct{} // closed and replaced whenever clients or their traffic change
+}
+
+// Start a new Server on a random local port, with the given lexicon.
+//
+// The server greets clients with version, and AutoLoad is on.
+func NewServer(version string, lexicon ...psx.MessageDef) (srv *Server, err error) {
+	srv = new(Server)
+	srv.Version = version
+	srv.AutoLoad = true
+	srv.lexicon = lexicon
+	srv.byName = make(map[string]*psx.MessageDef, len(lexicon))
+	for i := range srv.lexicon {
+		srv.byName[srv.lexicon[i].HumanName] = &srv.lexicon[i]
+	}
+	srv.state = make(map[string]string)
+	srv.changed = make(chan struct{})
+	srv.nextId = 1
+
+	srv.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go srv.acceptLoop()
+	return srv, nil
+}
+
+// Returns the host:port clients should connect to.
+func (srv *Server) Addr() string {
+	return srv.listener.Addr().String()
+}
+
+// Stop listening and drop all clients.
+func (srv *Server) Close() {
+	srv.listener.Close()
+	srv.Drop()
+}
+
+// wake anybody waiting on the server state.  Must be called with mu held.
+func (srv *Server) notifyChange() {
+	close(srv.changed)
+	srv.changed = make(chan struct{})
+}
+
+func (srv *Server) acceptLoop() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		client := &Client{srv: srv, conn: conn, Id: srv.nextId}
+		srv.nextId++
+		srv.clients = append(srv.clients, client)
+		// hold the client's write lock through the handshake so no
+		// broadcast can overtake it.
+		client.writeMu.Lock()
+		lines := srv.handshake(client.Id)
+		srv.notifyChange()
+		srv.mu.Unlock()
+
+		for _, line := range lines {
+			client.writeRaw(line)
+		}
+		client.writeMu.Unlock()
+		go client.readLoop()
+	}
+}
+
+// build the greeting for a new client.  Must be called with mu held.
+func (srv *Server) handshake(id int) (lines []string) {
+	lines = append(lines, "id="+strconv.Itoa(id), "version="+srv.Version)
+	for i := range srv.lexicon {
+		lines = append(lines, srv.lexicon[i].LexiconString())
+	}
+	if srv.AutoLoad {
+		lines = append(lines, srv.loadLines()...)
+	}
+	return lines
+}
+
+// the load sequence, including the full current state.  Must be called with
+// mu held.
+func (srv *Server) loadLines() (lines []string) {
+	lines = append(lines, "load1")
+	for _, key := range srv.order {
+		lines = append(lines, wireLine(key, srv.state[key]))
+	}
+	lines = append(lines, "load2", "load3")
+	return lines
+}
+
+// format a key/value pair using WireMsg
+func wireLine(key, value string) string {
+	msg := new(psx.WireMsg)
+	msg.SetKey(key)
+	msg.HasValue = true
+	msg.Value = value
+	return msg.WireString()
+}
+
+// Returns the wire key for the human name in the server's lexicon.
+func (srv *Server) KeyFor(humanName string) (key string, err error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	def, found := srv.byName[humanName]
+	if !found {
+		return "", UnknownNameError
+	}
+	return def.KeyString(), nil
+}
+
+// Update the named variable and send it to all clients.
+func (srv *Server) Set(humanName, value string) error {
+	key, err := srv.KeyFor(humanName)
+	if err != nil {
+		return err
+	}
+	srv.SetKey(key, value)
+	return nil
+}
+
+// Update the variable with the given wire key (eg: "Qi242") and send it to
+// all clients.
+func (srv *Server) SetKey(key, value string) {
+	srv.mu.Lock()
+	if _, found := srv.state[key]; !found {
+		srv.order = append(srv.order, key)
+	}
+	srv.state[key] = value
+	srv.mu.Unlock()
+	srv.SendLine(wireLine(key, value))
+}
+
+// Send msg to all clients.
+func (srv *Server) Send(msg *psx.WireMsg) {
+	srv.SendLine(msg.WireString())
+}
+
+// Send a raw line to all clients.
+func (srv *Server) SendLine(line string) {
+	for _, client := range srv.Clients() {
+		client.SendLine(line)
+	}
+}
+
+// Send the load1/load2/load3 sequence, with the full current state, to all
+// clients, as the simulator does when a situation is loaded.
+func (srv *Server) Load() {
+	srv.mu.Lock()
+	lines := srv.loadLines()
+	srv.mu.Unlock()
+	for _, line := range lines {
+		srv.SendLine(line)
+	}
+}
+
+// Send exit to all clients and close their connections, as the simulator
+// does when shutting down.
+func (srv *Server) Exit() {
+	srv.SendLine("exit")
+	srv.Drop()
+}
+
+// Abruptly close all client connections.
+func (srv *Server) Drop() {
+	for _, client := range srv.Clients() {
+		client.Drop()
+	}
+}
+
+// Returns all clients that have connected, including those since
+// disconnected, in connection order.
+func (srv *Server) Clients() []*Client {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]*Client(nil), srv.clients...)
+}
+
+// wait until cond (called with mu held) returns true.
+func (srv *Server) waitUntil(timeout time.Duration, cond func() bool) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		srv.mu.Lock()
+		if cond() {
+			srv.mu.Unlock()
+			return nil
+		}
+		changed := srv.changed
+		srv.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return TimeoutError
+		}
+	}
+}
+
+// Wait until at least n clients have connected (in total), and return the
+// nth.
+func (srv *Server) WaitClient(n int, timeout time.Duration) (client *Client, err error) {
+	err = srv.waitUntil(timeout, func() bool {
+		return len(srv.clients) >= n
+	})
+	if err != nil {
+		return nil, err
+	}
+	return srv.Clients()[n-1], nil
+}
+
+// Client is a single connection to the Server.
+type Client struct {
+	Id int // the id the server assigned
+
+	srv     *Server
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	// guarded by srv.mu
+	received []*psx.WireMsg
+	closed   bool
+}
+
+func (client *Client) readLoop() {
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		msg := new(psx.WireMsg)
+		msg.Parse(scanner.Text())
+		client.srv.mu.Lock()
+		client.received = append(client.received, msg)
+		client.srv.notifyChange()
+		client.srv.mu.Unlock()
+	}
+	client.conn.Close()
+	client.srv.mu.Lock()
+	client.closed = true
+	client.srv.notifyChange()
+	client.srv.mu.Unlock()
+}
+
+func (client *Client) writeRaw(line string) error {
+	_, err := client.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// Send a raw line to this client only.
+func (client *Client) SendLine(line string) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	return client.writeRaw(line)
+}
+
+// Send msg to this client only.
+func (client *Client) Send(msg *psx.WireMsg) error {
+	return client.SendLine(msg.WireString())
+}
+
+// Abruptly close this client's connection.
+func (client *Client) Drop() {
+	client.conn.Close()
+}
+
+// Returns true once the client has disconnected.
+func (client *Client) Closed() bool {
+	client.srv.mu.Lock()
+	defer client.srv.mu.Unlock()
+	return client.closed
+}
+
+// Returns every message the client has sent so far.
+func (client *Client) Received() []*psx.WireMsg {
+	client.srv.mu.Lock()
+	defer client.srv.mu.Unlock()
+	return append([]*psx.WireMsg(nil), client.received...)
+}
+
+// Wait for the client to send a message with the given wire key (eg: "name"
+// or "Qi242") and return the most recent one.
+func (client *Client) WaitFor(key string, timeout time.Duration) (msg *psx.WireMsg, err error) {
+	err = client.srv.waitUntil(timeout, func() bool {
+		for i := len(client.received) - 1; i >= 0; i-- {
+			if client.received[i].GetKey() == key {
+				msg = client.received[i]
+				return true
+			}
+		}
+		return false
+	})
+	return msg, err
+}
+
+// Wait for the client to disconnect.
+func (client *Client) WaitClosed(timeout time.Duration) error {
+	return client.srv.waitUntil(timeout, func() bool {
+		return client.closed
+	})
+}
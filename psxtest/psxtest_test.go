@@ -0,0 +1,128 @@
+package psxtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var testLexicon = []psx.MessageDef{
+	{MessageType: psx.MsgTypeH, MessageMode: psx.MsgModeCdukeyb, Index: 402, HumanName: "KeybCduC"},
+	{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+}
+
+func startConnection(t *testing.T, srv *Server) (pconn *psx.Connection, values chan string, done chan error) {
+	pconn, _ = psx.NewConnection(srv.Addr(), "test")
+	values = make(chan string, 10)
+	pconn.Hooks["UplinkBits"] = func(_ *psx.Connection, msg *psx.WireMsg) {
+		values <- msg.Value
+	}
+	done = make(chan error, 1)
+	go func() {
+		done <- pconn.Run(context.Background())
+	}()
+	return pconn, values, done
+}
+
+func expectValue(t *testing.T, values chan string, want string) {
+	select {
+	case got := <-values:
+		if got != want {
+			t.Errorf("Got value %q, expected %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for value %q", want)
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	srv, err := NewServer("10.0.0", testLexicon...)
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+	srv.Set("UplinkBits", "1")
+
+	pconn, values, done := startConnection(t, srv)
+	pconn.Subscribe("UplinkBits")
+
+	// initial state is sent during the load.
+	expectValue(t, values, "1")
+
+	client, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never connected: %s", err)
+	}
+	name, err := client.WaitFor("name", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never sent name: %s", err)
+	}
+	if name.Value != "test" {
+		t.Errorf("Unexpected name %q", name.Value)
+	}
+	notify, err := client.WaitFor("notify", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never sent notify: %s", err)
+	}
+	if notify.Value != "Qi242" {
+		t.Errorf("Unexpected notify %q", notify.Value)
+	}
+
+	srv.Set("UplinkBits", "2")
+	expectValue(t, values, "2")
+
+	pconn.SendMsg(pconn.NewPair("KeybCduC", "34"))
+	msg, err := client.WaitFor("Qh402", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Server never received update: %s", err)
+	}
+	if msg.Value != "34" {
+		t.Errorf("Server received unexpected value %q", msg.Value)
+	}
+
+	srv.Exit()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned unexpected error after exit: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after exit")
+	}
+}
+
+func TestServerDrop(t *testing.T) {
+	srv, err := NewServer("10.0.0", testLexicon...)
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+	srv.AutoLoad = false
+
+	pconn, _, done := startConnection(t, srv)
+	client, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client never connected: %s", err)
+	}
+	if _, err := client.WaitFor("name", 5*time.Second); err != nil {
+		t.Fatalf("Client never sent name: %s", err)
+	}
+	if pconn.State() != psx.StateNew {
+		t.Errorf("Client in unexpected state %s before load", pconn.State())
+	}
+
+	srv.Drop()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Run didn't report the dropped connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after drop")
+	}
+	if err := client.WaitClosed(5 * time.Second); err != nil {
+		t.Errorf("Client wasn't seen to close: %s", err)
+	}
+}
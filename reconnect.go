@@ -0,0 +1,140 @@
+package psx
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff defines how long RunWithReconnect waits between reconnection
+// attempts.
+//
+// The delay starts at Initial and is multiplied by Multiplier after every
+// consecutive failure until it reaches Max.  An Initial of zero (or less)
+// starts at DefaultBackoff.Initial instead, a Multiplier below 1 keeps the
+// delay constant, and a Max of zero (or less) leaves it uncapped.  Jitter
+// randomises each delay by up to that fraction of its value so a room full
+// of addons doesn't hammer the simulator in lockstep.
+type Backoff struct {
+	Initial     time.Duration // delay before the first reconnection attempt
+	Max         time.Duration // upper bound on the delay between attempts
+	Multiplier  float64       // growth factor applied after each failed attempt
+	Jitter      float64       // fraction (0-1) of the delay to randomise
+	MaxAttempts int           // consecutive failures before giving up.  0 retries forever
+}
+
+// The Backoff a new Connection is initialised with.
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2.0,
+	Jitter:     0.2,
+}
+
+// the shortest delay Backoff will return, whatever the jitter.
+const minBackoffDelay = time.Millisecond
+
+// Returns the delay to wait before the numbered reconnection attempt
+// (starting at 1).
+func (b Backoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	limit := float64(math.MaxInt64)
+	if b.Max > 0 {
+		limit = float64(b.Max)
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		// never shrink the delay.
+		multiplier = 1
+	}
+	// a zero delay would reconnect in a hot loop.
+	delay := float64(b.Initial)
+	if b.Initial <= 0 {
+		delay = float64(DefaultBackoff.Initial)
+	}
+	for i := 1; i < attempt && delay < limit && multiplier > 1; i++ {
+		delay *= multiplier
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < float64(minBackoffDelay) {
+		delay = float64(minBackoffDelay)
+	}
+	if delay >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+// ReconnectHooks are called by RunWithReconnect after a connection attempt
+// fails or an established connection is lost, before it waits to retry.
+//
+// attempt counts the consecutive failures (starting at 1), delay is how long
+// the supervisor will wait before the next attempt, and err is the reason the
+// last connection ended (nil if the server sent exit).
+type ReconnectHook func(pconn *Connection, attempt int, delay time.Duration, err error)
+
+// ConnectHooks are called by Run (and so on every attempt made by
+// RunWithReconnect) each time a connection to the server is established,
+// before any messages have been read.
+type ConnectHook func(pconn *Connection)
+
+// Run the connection, reconnecting whenever it is lost, until ctx is
+// cancelled.
+//
+// Reconnection attempts are paced according to pconn.Backoff.  Every new
+// connection re-sends our name and notify list, and re-learns the lexicon,
+// exactly as the first one did.  The consecutive failure count is reset once
+// a connection makes it to load3.
+//
+// Returns ctx.Err() when cancelled, or the last connection error if
+// Backoff.MaxAttempts is exhausted.
+func (pconn *Connection) RunWithReconnect(ctx context.Context) error {
+	return pconn.Backoff.supervise(ctx, func() (established bool, err error) {
+		err = pconn.Run(ctx)
+		return pconn.loaded(), err
+	}, func(attempt int, delay time.Duration, err error) {
+		if pconn.OnReconnect != nil {
+			pconn.OnReconnect(pconn, attempt, delay, err)
+		}
+	})
+}
+
+// call run until ctx is cancelled, pacing the calls according to b.
+//
+// run makes a single connection, returning the error that ended it and
+// whether it got far enough to reset the consecutive failure count.
+// onRetry is called before waiting to call run again.
+func (b Backoff) supervise(ctx context.Context, run func() (established bool, err error), onRetry func(attempt int, delay time.Duration, err error)) error {
+	attempt := 0
+	for {
+		established, err := run()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if established {
+			attempt = 0
+		}
+		attempt++
+		if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+			return err
+		}
+		delay := b.Delay(attempt)
+		onRetry(attempt, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
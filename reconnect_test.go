@@ -0,0 +1,164 @@
+package psx
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2.0}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := b.Delay(i + 1); got != want {
+			t.Errorf("Attempt %d: got delay %s, expected %s", i+1, got, want)
+		}
+	}
+}
+
+func TestBackoffZeroValues(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		b        Backoff
+		expected []time.Duration
+	}{
+		{"zero multiplier", Backoff{Initial: time.Second, Max: 5 * time.Second},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+		{"shrinking multiplier", Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 0.5},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+		{"zero max", Backoff{Initial: time.Second, Multiplier: 2.0},
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}},
+		{"zero multiplier and max", Backoff{Initial: time.Second},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+		{"zero initial", Backoff{Max: 5 * time.Second, Multiplier: 2.0},
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}},
+		{"negative initial", Backoff{Initial: -time.Second},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+		{"zero value", Backoff{},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+	} {
+		for i, want := range test.expected {
+			if got := test.b.Delay(i + 1); got != want {
+				t.Errorf("%s, attempt %d: got delay %s, expected %s", test.name, i+1, got, want)
+			}
+		}
+	}
+	// an uncapped delay mustn't overflow.
+	if got := (Backoff{Initial: time.Second, Multiplier: 2.0}).Delay(100); got <= 0 {
+		t.Errorf("Uncapped delay overflowed to %s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Second, Multiplier: 2.0, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		got := b.Delay(1)
+		if got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("Jittered delay %s out of range", got)
+		}
+	}
+	// even full jitter never reconnects without waiting.
+	b = Backoff{Initial: time.Millisecond, Jitter: 1}
+	for i := 0; i < 100; i++ {
+		if got := b.Delay(1); got < minBackoffDelay {
+			t.Fatalf("Jittered delay %s below the minimum", got)
+		}
+	}
+}
+
+func TestRunWithReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	connections := 0
+
+	addr := startFakeServer(t, func(c net.Conn) {
+		mu.Lock()
+		connections++
+		first := connections == 1
+		mu.Unlock()
+
+		sendPreamble(c)
+		if first {
+			// drop the first client abruptly once it's loaded.
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			mu.Lock()
+			received = append(received, scanner.Text())
+			mu.Unlock()
+		}
+	})
+
+	pconn, _ := NewConnection(addr, "test")
+	pconn.Backoff = Backoff{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond, Multiplier: 1.0}
+	pconn.Subscribe("UplinkBits")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reconnects := 0
+	pconn.OnReconnect = func(_ *Connection, attempt int, _ time.Duration, err error) {
+		reconnects++
+		if attempt != 1 {
+			t.Errorf("Unexpected attempt count %d after a loaded session", attempt)
+		}
+		if err == nil {
+			t.Error("Lost connection reported no error")
+		}
+	}
+	loads := 0
+	pconn.Hooks["load3"] = func(_ *Connection, _ *WireMsg) {
+		loads++
+		if loads == 2 {
+			// give the server a moment to see our notify.
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+	}
+
+	err := pconn.RunWithReconnect(ctx)
+	if err != context.Canceled {
+		t.Errorf("RunWithReconnect returned unexpected error: %v", err)
+	}
+	if reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", reconnects)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := strings.Join(received, "|")
+	if !strings.Contains(got, "name=test") {
+		t.Errorf("Name wasn't resent after reconnect: %s", got)
+	}
+	if !strings.Contains(got, "notify=Qi242") {
+		t.Errorf("Notify wasn't resent after reconnect: %s", got)
+	}
+}
+
+func TestRunWithReconnectGivesUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	addr := l.Addr().String()
+	// nobody home.
+	l.Close()
+
+	pconn, _ := NewConnection(addr, "test")
+	pconn.Backoff = Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1.0, MaxAttempts: 3}
+	attempts := 0
+	pconn.OnReconnect = func(_ *Connection, attempt int, _ time.Duration, _ error) {
+		attempts = attempt
+	}
+	err = pconn.RunWithReconnect(context.Background())
+	if err == nil {
+		t.Error("Expected a dial error once attempts were exhausted")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 reconnect attempts, got %d", attempts)
+	}
+}
@@ -0,0 +1,510 @@
+// Provides a Router/SwitchPSX compatible multiplexer.
+//
+// A Router holds a single upstream psx.Connection to the simulator and
+// serves any number of downstream clients.  Downstream writes are forwarded
+// upstream (and echoed to the other downstream clients, as the simulator
+// would), whilst upstream updates are fanned out to every client, filtered by
+// that client's notify list.
+//
+// Clients that join late are sent the lexicon and the current value of every
+// variable the Router has seen, so they start in the same state as everybody
+// else.
+package router
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	// Returned by Serve once the Router has been closed.
+	RouterClosedError = errors.New("router: closed")
+
+	// A client demanded a variable the upstream lexicon doesn't know.
+	UnknownDemandError = errors.New("router: demand for unknown variable")
+)
+
+// ClientErrorHook is called when a client sends a request the Router can't
+// act on.
+type ClientErrorHook func(info ClientInfo, err error)
+
+// Default number of lines buffered for each client before the client is
+// considered too slow and disconnected.
+const DefaultClientBuffer = 4096
+
+// Router multiplexes a single upstream PSX connection to many clients.
+//
+// Use New to initialise a Router.
+type Router struct {
+	// Lines buffered per client before a slow client is dropped.
+	ClientBuffer int
+
+	// Called when a client's request can't be acted on - eg: a demand for
+	// a variable the upstream doesn't know.  The client itself isn't told.
+	OnClientError ClientErrorHook
+
+	upstream *psx.Connection
+
+	mu        sync.Mutex
+	clients   map[int]*client
+	nextId    int
+	version   string
+	lexLines  []string          // lexicon lines, in the order the server sent them
+	state     map[string]string // latest value for each Q key
+	order     []string          // Q keys in the order they were first seen
+	loadPhase int               // highest load message seen this session (0-3)
+	listeners []net.Listener
+	closed    bool
+}
+
+// Create a new Router relaying the upstream Connection.
+//
+// The Router adds a hook for every message to the upstream Connection, and
+// sends its subscriptions and demands on behalf of the clients, so the
+// Connection should be dedicated to the Router.  The Router doesn't start
+// the upstream Connection - run it (typically with RunWithReconnect)
+// alongside Serve.
+func New(upstream *psx.Connection) *Router {
+	r := new(Router)
+	r.ClientBuffer = DefaultClientBuffer
+	r.upstream = upstream
+	r.clients = make(map[int]*client)
+	r.nextId = 1
+	r.state = make(map[string]string)
+	upstream.AddPredicateHook(func(*psx.WireMsg) bool { return true }, r.upstreamMessage)
+	return r
+}
+
+// handle a message from the upstream server.
+func (r *Router) upstreamMessage(_ *psx.Connection, msg *psx.WireMsg) {
+	key := msg.GetKey()
+	line := msg.WireString()
+
+	r.mu.Lock()
+	switch {
+	case key == "id":
+		// a new upstream session - forget everything we knew about
+		// the last one.  The id itself is ours, not our clients'.
+		r.lexLines = nil
+		r.state = make(map[string]string)
+		r.order = nil
+		r.loadPhase = 0
+		r.mu.Unlock()
+		return
+	case key == "version":
+		r.version = msg.Value
+	case key == "load1":
+		r.loadPhase = 1
+	case key == "load2":
+		r.loadPhase = 2
+	case key == "load3":
+		r.loadPhase = 3
+	case len(key) > 0 && key[0] == 'L' && msg.HasValue:
+		r.lexLines = append(r.lexLines, line)
+	case psx.IsQKey(key) && msg.HasValue:
+		r.setState(key, msg.Value)
+	}
+	clients := r.clientList()
+	r.mu.Unlock()
+
+	for _, c := range clients {
+		if c.wants(key) {
+			c.send(line)
+		}
+	}
+	if key == "exit" {
+		// the simulator is going away - so are our clients.
+		for _, c := range clients {
+			c.close()
+		}
+	}
+}
+
+// record the latest value of a Q variable.  Must be called with mu held.
+func (r *Router) setState(key, value string) {
+	if _, found := r.state[key]; !found {
+		r.order = append(r.order, key)
+	}
+	r.state[key] = value
+}
+
+// snapshot of the current clients.  Must be called with mu held.
+func (r *Router) clientList() []*client {
+	clients := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// ClientInfo describes a connected client.
+type ClientInfo struct {
+	Id         int    // id assigned by the Router
+	Name       string // name the client reported, if any
+	RemoteAddr string
+}
+
+// Returns the currently connected clients, ordered by id.
+func (r *Router) Clients() []ClientInfo {
+	r.mu.Lock()
+	clients := r.clientList()
+	r.mu.Unlock()
+
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+	return infos
+}
+
+// Listen on addr and serve clients until ctx is cancelled.
+func (r *Router) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(ctx, l)
+}
+
+// Accept and serve clients on l until ctx is cancelled or the Router is
+// closed.  l is closed when Serve returns.
+func (r *Router) Serve(ctx context.Context, l net.Listener) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		l.Close()
+		return RouterClosedError
+	}
+	r.listeners = append(r.listeners, l)
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			l.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.mu.Lock()
+			closed := r.closed
+			r.mu.Unlock()
+			if closed {
+				return RouterClosedError
+			}
+			return err
+		}
+		r.addClient(conn)
+	}
+}
+
+// Stop serving and disconnect all clients.  The upstream Connection is
+// left alone.
+func (r *Router) Close() {
+	r.mu.Lock()
+	r.closed = true
+	listeners := r.listeners
+	r.listeners = nil
+	clients := r.clientList()
+	r.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for _, c := range clients {
+		c.close()
+	}
+}
+
+// register a new client and greet it with the current session.
+func (r *Router) addClient(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	buffer := r.ClientBuffer
+	if buffer <= 0 {
+		buffer = DefaultClientBuffer
+	}
+
+	r.mu.Lock()
+	id := r.nextId
+	r.nextId++
+	// build and queue the greeting whilst holding mu so no upstream
+	// update can overtake it.
+	greeting := []string{"id=" + strconv.Itoa(id)}
+	if r.version != "" {
+		greeting = append(greeting, "version="+r.version)
+	}
+	greeting = append(greeting, r.lexLines...)
+	if r.loadPhase >= 1 {
+		greeting = append(greeting, "load1")
+		for _, key := range r.order {
+			greeting = append(greeting, key+"="+r.state[key])
+		}
+	}
+	if r.loadPhase >= 2 {
+		greeting = append(greeting, "load2")
+	}
+	if r.loadPhase >= 3 {
+		greeting = append(greeting, "load3")
+	}
+	// the greeting mustn't count against the client's buffer.
+	c := newClient(r, conn, id, buffer+len(greeting))
+	for _, line := range greeting {
+		c.send(line)
+	}
+	r.clients[c.id] = c
+	r.mu.Unlock()
+
+	go c.writeLoop()
+	go c.readLoop()
+}
+
+// forget a client that has gone away.
+func (r *Router) removeClient(c *client) {
+	r.mu.Lock()
+	delete(r.clients, c.id)
+	r.mu.Unlock()
+}
+
+// handle a message from a downstream client.
+func (r *Router) clientMessage(from *client, msg *psx.WireMsg) {
+	key := msg.GetKey()
+	switch {
+	case key == "name":
+		from.setName(msg.Value)
+	case key == "notify":
+		from.setNotify(msg.Value)
+	case key == "exit":
+		from.close()
+	case key == "demand" || key == "nodemand":
+		r.clientDemand(from, msg.Value, key == "demand")
+	case psx.IsQKey(key) && msg.HasValue:
+		r.mu.Lock()
+		r.setState(key, msg.Value)
+		clients := r.clientList()
+		r.mu.Unlock()
+
+		line := msg.WireString()
+		for _, c := range clients {
+			if c != from && c.wants(key) {
+				c.send(line)
+			}
+		}
+		r.upstream.SendMsg(msg)
+	default:
+		r.upstream.SendMsg(msg)
+	}
+}
+
+// track a client's demand (or withdrawal) of a demand mode variable.
+//
+// Demands are placed on the upstream Connection on the clients' behalf so
+// they are counted across clients and survive upstream reconnections.
+func (r *Router) clientDemand(from *client, key string, demand bool) {
+	if _, found := r.upstream.Lexicon().LookupKey(key); !found {
+		// it can't be sent upstream, so there's nothing to track.
+		r.clientError(from, fmt.Errorf("%w: %q", UnknownDemandError, key))
+		return
+	}
+	from.mu.Lock()
+	if from.demands == nil {
+		from.demands = make(map[string]bool)
+	}
+	changed := from.demands[key] != demand
+	if demand {
+		from.demands[key] = true
+	} else {
+		delete(from.demands, key)
+	}
+	from.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	name := r.upstreamName(key)
+	if !demand {
+		r.upstream.Undemand(name)
+		return
+	}
+	if err := r.upstream.Demand(name); err != nil {
+		// the upstream didn't count it, so the client mustn't withdraw it.
+		from.mu.Lock()
+		delete(from.demands, key)
+		from.mu.Unlock()
+		r.clientError(from, fmt.Errorf("demand %q: %w", key, err))
+	}
+}
+
+// report a client request that couldn't be acted on.
+func (r *Router) clientError(from *client, err error) {
+	if r.OnClientError != nil {
+		r.OnClientError(from.info(), err)
+	}
+}
+
+// withdraw everything a departing client demanded.
+func (r *Router) releaseDemands(c *client) {
+	c.mu.Lock()
+	demands := c.demands
+	c.demands = nil
+	c.mu.Unlock()
+	for key := range demands {
+		r.upstream.Undemand(r.upstreamName(key))
+	}
+}
+
+// decode a Q key using the upstream lexicon.
+func (r *Router) upstreamName(key string) string {
+	msg := r.upstream.NewWireMsg()
+	msg.SetKey(key)
+	return msg.GetDecodedKey()
+}
+
+// client is a single downstream connection.
+type client struct {
+	router *Router
+	conn   net.Conn
+	id     int
+	out    chan string
+
+	mu      sync.Mutex
+	name    string
+	notify  map[string]bool // nil means everything
+	demands map[string]bool // demand variables requested, by Q key
+	closed  bool
+}
+
+func newClient(r *Router, conn net.Conn, id int, buffer int) *client {
+	c := new(client)
+	c.router = r
+	c.conn = conn
+	c.id = id
+	c.out = make(chan string, buffer)
+	return c
+}
+
+func (c *client) info() ClientInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClientInfo{Id: c.id, Name: c.name, RemoteAddr: c.conn.RemoteAddr().String()}
+}
+
+func (c *client) setName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// replace the client's notify filter with the ; delimited list of Q keys.
+func (c *client) setNotify(list string) {
+	notify := make(map[string]bool)
+	for _, key := range strings.Split(list, ";") {
+		if key != "" {
+			notify[key] = true
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(notify) == 0 {
+		c.notify = nil
+	} else {
+		c.notify = notify
+	}
+}
+
+// returns true if the client should be sent messages with the given key.
+//
+// notify only filters Q variables - everything else goes to everybody.
+func (c *client) wants(key string) bool {
+	if !psx.IsQKey(key) {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.notify == nil || c.notify[key]
+}
+
+// queue a line for the client, dropping the client if it can't keep up.
+func (c *client) send(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.out <- line:
+	default:
+		c.closeLocked()
+	}
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+// must be called with mu held.
+func (c *client) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.out)
+}
+
+func (c *client) writeLoop() {
+	writer := bufio.NewWriter(c.conn)
+	for line := range c.out {
+		writer.WriteString(line)
+		writer.WriteString("\r\n")
+		// only flush once we've caught up with the queue.
+		if len(c.out) == 0 {
+			if err := writer.Flush(); err != nil {
+				break
+			}
+		}
+	}
+	writer.Flush()
+	c.conn.Close()
+	c.close()
+	c.router.removeClient(c)
+}
+
+func (c *client) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			msg := new(psx.WireMsg)
+			msg.Parse(line)
+			c.router.clientMessage(c, msg)
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.close()
+	c.router.releaseDemands(c)
+}
@@ -0,0 +1,257 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/psxtest"
+)
+
+var testLexicon = []psx.MessageDef{
+	{MessageType: psx.MsgTypeH, MessageMode: psx.MsgModeCdukeyb, Index: 402, HumanName: "KeybCduC"},
+	{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+}
+
+// a downstream client, reporting every update it sees on values.
+type testClient struct {
+	pconn  *psx.Connection
+	values chan string
+}
+
+func startClient(t *testing.T, ctx context.Context, addr string, subscribe ...string) *testClient {
+	tc := &testClient{values: make(chan string, 10)}
+	tc.pconn, _ = psx.NewConnection(addr, "client")
+	for _, name := range subscribe {
+		tc.pconn.Subscribe(name)
+	}
+	hook := func(_ *psx.Connection, msg *psx.WireMsg) {
+		tc.values <- msg.String()
+	}
+	tc.pconn.Hooks["UplinkBits"] = hook
+	tc.pconn.Hooks["KeybCduC"] = hook
+	running := make(chan bool)
+	tc.pconn.OnStateChange(func(_, newState psx.ConnState) {
+		if newState == psx.StateRunning {
+			close(running)
+		}
+	})
+	go tc.pconn.Run(ctx)
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client never finished loading")
+	}
+	return tc
+}
+
+func (tc *testClient) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-tc.values:
+		if got != want {
+			t.Errorf("Client %d got %q, expected %q", tc.pconn.Id(), got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Client %d timed out waiting for %q", tc.pconn.Id(), want)
+	}
+}
+
+func (tc *testClient) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-tc.values:
+		t.Errorf("Client %d got unexpected update %q", tc.pconn.Id(), got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRouter(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0", testLexicon...)
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+	srv.Set("UplinkBits", "5")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upstream, _ := psx.NewConnection(srv.Addr(), "router")
+	rtr := New(upstream)
+	defer rtr.Close()
+	go upstream.Run(ctx)
+	upstreamClient, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Router never connected upstream: %s", err)
+	}
+	if _, err := upstreamClient.WaitFor("name", 5*time.Second); err != nil {
+		t.Fatalf("Router never identified itself: %s", err)
+	}
+	// the router must never filter what it receives itself.
+	if msgs := upstreamClient.Received(); len(msgs) != 1 {
+		t.Errorf("Router sent unexpected messages upstream: %v", msgs)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go rtr.Serve(ctx, l)
+
+	// wait for the upstream to finish loading, so our clients are late
+	// joiners.
+	for upstream.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	filtered := startClient(t, ctx, l.Addr().String(), "KeybCduC")
+	everything := startClient(t, ctx, l.Addr().String())
+	if filtered.pconn.Id() == everything.pconn.Id() {
+		t.Errorf("Clients were assigned the same id %d", filtered.pconn.Id())
+	}
+
+	// both late joiners get the current state replayed.
+	filtered.expect(t, "UplinkBits=5")
+	everything.expect(t, "UplinkBits=5")
+
+	// wait for the filtered client's notify to reach the router.
+	for i := 0; i < 100 && len(rtr.Clients()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	srv.Set("UplinkBits", "6")
+	everything.expect(t, "UplinkBits=6")
+	filtered.expectNothing(t)
+
+	srv.Set("KeybCduC", "1")
+	everything.expect(t, "KeybCduC=1")
+	filtered.expect(t, "KeybCduC=1")
+
+	// writes go upstream, and to the other clients.
+	everything.pconn.SendMsg(everything.pconn.NewPair("KeybCduC", "2"))
+	msg, err := upstreamClient.WaitFor("Qh402", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Client write never reached upstream: %s", err)
+	}
+	if msg.Value != "2" {
+		t.Errorf("Upstream received unexpected value %q", msg.Value)
+	}
+	filtered.expect(t, "KeybCduC=2")
+	everything.expectNothing(t)
+
+	if clients := rtr.Clients(); len(clients) != 2 || clients[0].Name != "client" {
+		t.Errorf("Unexpected client list: %v", clients)
+	}
+}
+
+func TestRouterUnsubscribeAll(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0", testLexicon...)
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+	srv.Set("UplinkBits", "5")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upstream, _ := psx.NewConnection(srv.Addr(), "router")
+	rtr := New(upstream)
+	defer rtr.Close()
+	go upstream.Run(ctx)
+	for upstream.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go rtr.Serve(ctx, l)
+
+	tc := startClient(t, ctx, l.Addr().String(), "KeybCduC")
+	tc.expect(t, "UplinkBits=5")
+	time.Sleep(50 * time.Millisecond)
+	srv.Set("UplinkBits", "6")
+	tc.expectNothing(t)
+
+	// dropping the last subscription lifts the filter straight away.
+	tc.pconn.Unsubscribe("KeybCduC")
+	time.Sleep(50 * time.Millisecond)
+	srv.Set("UplinkBits", "7")
+	tc.expect(t, "UplinkBits=7")
+}
+
+func TestRouterDemand(t *testing.T) {
+	lexicon := append([]psx.MessageDef{
+		{MessageType: psx.MsgTypeS, MessageMode: psx.MsgModeDemand, Index: 440, HumanName: "TcasData"},
+	}, testLexicon...)
+	srv, err := psxtest.NewServer("10.0.0", lexicon...)
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upstream, _ := psx.NewConnection(srv.Addr(), "router")
+	rtr := New(upstream)
+	defer rtr.Close()
+	clientErrors := make(chan error, 1)
+	rtr.OnClientError = func(_ ClientInfo, err error) {
+		clientErrors <- err
+	}
+	go upstream.Run(ctx)
+	upstreamClient, err := srv.WaitClient(1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Router never connected upstream: %s", err)
+	}
+	for upstream.State() != psx.StateRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go rtr.Serve(ctx, l)
+
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	tc := startClient(t, clientCtx, l.Addr().String())
+	tc.pconn.Demand("TcasData")
+	msg, err := upstreamClient.WaitFor("demand", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Demand never reached upstream: %s", err)
+	}
+	if msg.Value != "Qs440" {
+		t.Errorf("Unexpected demand %q", msg.Value)
+	}
+
+	// a demand for something the upstream doesn't know is reported, not
+	// passed on.
+	tc.pconn.SendMsg(tc.pconn.NewPair("demand", "Qs999"))
+	select {
+	case err := <-clientErrors:
+		if !errors.Is(err, UnknownDemandError) {
+			t.Errorf("Unexpected client error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Unknown demand was never reported")
+	}
+	if demands := upstream.Demands(); len(demands) != 1 || demands[0] != "TcasData" {
+		t.Errorf("Upstream demands are %v", demands)
+	}
+
+	// the demand is withdrawn when the client goes away.
+	clientCancel()
+	msg, err = upstreamClient.WaitFor("nodemand", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Demand was never withdrawn upstream: %s", err)
+	}
+	if msg.Value != "Qs440" {
+		t.Errorf("Unexpected withdrawal %q", msg.Value)
+	}
+}
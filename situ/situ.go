@@ -0,0 +1,320 @@
+// Reads, edits and writes PSX situation files.
+//
+// A situation file is a list of Qi/Qs/Qh assignments in the same format the
+// main server uses on the wire:
+//
+//	Qi138=5
+//	Qs121=0.0174;-0.0052;1.5708;35000000;480000
+//
+// Situation parses each assignment into a *psx.WireMsg, linked to a
+// lexicon so variables can be handled by their human names.  Everything
+// else in the file - other lines, line endings, a missing final newline -
+// is kept, so writing an unedited Situation back reproduces the file byte
+// for byte, and an edited one differs only in the lines that were changed.
+package situ
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kuroneko/psx.go"
+)
+
+var (
+	// The name given is neither a Q key nor known to the lexicon.
+	UnknownVariableError = errors.New("Unknown situation variable")
+)
+
+// the line ending used for added lines when the file doesn't have one.
+const defaultEOL = "\r\n"
+
+// a single line of the file.
+type line struct {
+	raw string       // the line as read, without its line ending
+	eol string       // the line ending - "" on an unterminated final line
+	msg *psx.WireMsg // the assignment on this line, nil for any other line
+}
+
+// Situation holds the contents of a situation file.
+//
+// Use Parse or Load to initialise a Situation.
+type Situation struct {
+	lex   *psx.Lexicon
+	lines []*line
+	keys  map[string]*line // assignment lines by wire key
+}
+
+// Parse a situation file, resolving names with lex.  lex may be nil, in
+// which case variables can only be referred to by their wire keys.
+func Parse(r io.Reader, lex *psx.Lexicon) (sit *Situation, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sit = &Situation{
+		lex:  lex,
+		keys: make(map[string]*line),
+	}
+	for len(data) > 0 {
+		l := new(line)
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			l.raw = string(data)
+			data = nil
+		} else {
+			l.raw = string(data[:end])
+			l.eol = "\n"
+			data = data[end+1:]
+			if len(l.raw) > 0 && l.raw[len(l.raw)-1] == '\r' {
+				l.raw = l.raw[:len(l.raw)-1]
+				l.eol = "\r\n"
+			}
+		}
+		msg := sit.newMsg()
+		msg.Parse(l.raw)
+		if msg.HasValue && psx.IsQKey(msg.GetKey()) {
+			l.msg = msg
+			// if a key is assigned more than once, the last one wins, as it
+			// would when the lines are sent to the server.
+			sit.keys[msg.GetKey()] = l
+		}
+		sit.lines = append(sit.lines, l)
+	}
+	return sit, nil
+}
+
+// Read the situation file at path, resolving names with lex.
+func Load(path string, lex *psx.Lexicon) (sit *Situation, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, lex)
+}
+
+// returns a new WireMsg linked to the lexicon, if there is one.
+func (sit *Situation) newMsg() *psx.WireMsg {
+	if sit.lex == nil {
+		return new(psx.WireMsg)
+	}
+	return sit.lex.NewWireMsg()
+}
+
+// returns the wire key for name, which may be a human name or a wire key.
+func (sit *Situation) keyFor(name string) (key string, err error) {
+	if sit.lex != nil {
+		if def, found := sit.lex.LookupName(name); found {
+			return def.KeyString(), nil
+		}
+	}
+	if psx.IsQKey(name) {
+		return name, nil
+	}
+	return "", fmt.Errorf("%w: %q", UnknownVariableError, name)
+}
+
+// Returns the situation's lexicon.
+func (sit *Situation) Lexicon() *psx.Lexicon {
+	return sit.lex
+}
+
+// Returns the number of variables assigned.
+func (sit *Situation) Len() int {
+	return len(sit.keys)
+}
+
+// Returns the assignments in the order they appear in the file.  A
+// variable assigned more than once is only returned at its last
+// assignment.
+//
+// The messages are the Situation's own - changing their values edits the
+// situation.
+func (sit *Situation) Messages() (msgs []*psx.WireMsg) {
+	for _, l := range sit.lines {
+		if l.msg != nil && sit.keys[l.msg.GetKey()] == l {
+			msgs = append(msgs, l.msg)
+		}
+	}
+	return msgs
+}
+
+// Returns the assignment for the variable with the given human name or wire
+// key, and whether it was found.
+func (sit *Situation) Get(name string) (msg *psx.WireMsg, found bool) {
+	key, err := sit.keyFor(name)
+	if err != nil {
+		return nil, false
+	}
+	l, found := sit.keys[key]
+	if !found {
+		return nil, false
+	}
+	return l.msg, true
+}
+
+// Returns the value of the variable with the given human name or wire key,
+// or the empty string if it isn't assigned.
+func (sit *Situation) Value(name string) string {
+	if msg, found := sit.Get(name); found {
+		return msg.Value
+	}
+	return ""
+}
+
+// Set the variable with the given human name or wire key.  A variable that
+// isn't already assigned is added at the end of the file.
+func (sit *Situation) Set(name, value string) error {
+	key, err := sit.keyFor(name)
+	if err != nil {
+		return err
+	}
+	if l, found := sit.keys[key]; found {
+		l.msg.Value = value
+		return nil
+	}
+
+	eol := defaultEOL
+	if len(sit.lines) > 0 {
+		last := sit.lines[len(sit.lines)-1]
+		if last.eol == "" {
+			// the file didn't end with a newline - give the old last line
+			// the same ending as its neighbours so the new line is separate.
+			last.eol = sit.eol()
+		}
+		eol = sit.eol()
+	}
+	msg := sit.newMsg()
+	msg.SetKey(key)
+	msg.HasValue = true
+	msg.Value = value
+	l := &line{msg: msg, eol: eol}
+	sit.lines = append(sit.lines, l)
+	sit.keys[key] = l
+	return nil
+}
+
+// returns the file's line ending - the first one used, or the default if
+// there are none.
+func (sit *Situation) eol() string {
+	for _, l := range sit.lines {
+		if l.eol != "" {
+			return l.eol
+		}
+	}
+	return defaultEOL
+}
+
+// Remove every assignment of the variable with the given human name or wire
+// key.  Returns true if it was assigned.
+func (sit *Situation) Remove(name string) bool {
+	key, err := sit.keyFor(name)
+	if err != nil {
+		return false
+	}
+	if _, found := sit.keys[key]; !found {
+		return false
+	}
+	delete(sit.keys, key)
+	lines := sit.lines[:0]
+	for _, l := range sit.lines {
+		if l.msg == nil || l.msg.GetKey() != key {
+			lines = append(lines, l)
+		}
+	}
+	sit.lines = lines
+	return true
+}
+
+// Write the situation out in situation file format.
+func (sit *Situation) WriteTo(w io.Writer) (n int64, err error) {
+	var buf bytes.Buffer
+	for _, l := range sit.lines {
+		if l.msg != nil {
+			buf.WriteString(l.msg.WireString())
+		} else {
+			buf.WriteString(l.raw)
+		}
+		buf.WriteString(l.eol)
+	}
+	return buf.WriteTo(w)
+}
+
+// Save the situation to path.
+func (sit *Situation) Save(path string) error {
+	// write then rename, so a crash can't leave a truncated file behind.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = sit.WriteTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Change is a single difference between two situations.
+type Change struct {
+	Name string       // the human name, or wire key if the lexicon doesn't know it
+	Old  *psx.WireMsg // the assignment in from, nil if it was added
+	New  *psx.WireMsg // the assignment in to, nil if it was removed
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s added: %s", c.Name, c.New.Value)
+	case c.New == nil:
+		return fmt.Sprintf("%s removed: %s", c.Name, c.Old.Value)
+	}
+	return fmt.Sprintf("%s changed: %s -> %s", c.Name, c.Old.Value, c.New.Value)
+}
+
+// returns the assignments in sit by human name.
+func (sit *Situation) byName() map[string]*psx.WireMsg {
+	names := make(map[string]*psx.WireMsg, len(sit.keys))
+	for _, l := range sit.keys {
+		names[l.msg.GetDecodedKey()] = l.msg
+	}
+	return names
+}
+
+// Compare two situations by human name, returning the variables added,
+// removed or changed in to relative to from, ordered by name.
+//
+// Matching by name rather than wire key means situations saved by different
+// PSX versions compare correctly, provided each was loaded with its own
+// version's lexicon.
+func Diff(from, to *Situation) (changes []Change) {
+	oldNames, newNames := from.byName(), to.byName()
+	for name, oldMsg := range oldNames {
+		newMsg, found := newNames[name]
+		switch {
+		case !found:
+			changes = append(changes, Change{Name: name, Old: oldMsg})
+		case oldMsg.Value != newMsg.Value:
+			changes = append(changes, Change{Name: name, Old: oldMsg, New: newMsg})
+		}
+	}
+	for name, newMsg := range newNames {
+		if _, found := oldNames[name]; !found {
+			changes = append(changes, Change{Name: name, New: newMsg})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
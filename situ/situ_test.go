@@ -0,0 +1,115 @@
+package situ
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kuroneko/psx.go"
+)
+
+// mixed line endings, a non-assignment line and no final newline, to check
+// they all survive a round trip.
+const testSituation = "; Heathrow 27L, CAVOK\r\n" +
+	"Qi138=5\r\n" +
+	"Qs121=0.0174;-0.0052;1.5708;35000000;480000\r\n" +
+	"\r\n" +
+	"Qh4=1\n" +
+	"Qi242=0"
+
+func testLexicon() *psx.Lexicon {
+	return psx.NewLexicon(
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeStart, Index: 138, HumanName: "FltControls"},
+		psx.MessageDef{MessageType: psx.MsgTypeS, MessageMode: psx.MsgModeEcon, Index: 121, HumanName: "PiBaHeAlTas"},
+		psx.MessageDef{MessageType: psx.MsgTypeH, MessageMode: psx.MsgModeStart, Index: 4, HumanName: "Reserved4"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeXecon, Index: 242, HumanName: "UplinkBits"},
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeStart, Index: 99, HumanName: "Brakes"},
+	)
+}
+
+func write(t *testing.T, sit *Situation) string {
+	var buf bytes.Buffer
+	if _, err := sit.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %s", err)
+	}
+	return buf.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	sit, err := Parse(strings.NewReader(testSituation), testLexicon())
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	if sit.Len() != 4 {
+		t.Errorf("Len %d, expected 4", sit.Len())
+	}
+	if msgs := sit.Messages(); len(msgs) != 4 || msgs[1].GetDecodedKey() != "PiBaHeAlTas" {
+		t.Errorf("Bad messages: %v", msgs)
+	}
+	if got := write(t, sit); got != testSituation {
+		t.Errorf("Round trip changed the file: %q", got)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	sit, _ := Parse(strings.NewReader(testSituation), testLexicon())
+
+	if sit.Value("FltControls") != "5" || sit.Value("Qi138") != "5" {
+		t.Errorf("Lookup by name or key failed")
+	}
+	if err := sit.Set("UplinkBits", "3"); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := sit.Set("Brakes", "1"); err != nil {
+		t.Fatalf("Set of new variable failed: %s", err)
+	}
+	if err := sit.Set("NoSuchThing", "1"); !errors.Is(err, UnknownVariableError) {
+		t.Errorf("Set of unknown name returned %v", err)
+	}
+	if !sit.Remove("Reserved4") || sit.Remove("Reserved4") {
+		t.Errorf("Remove didn't report correctly")
+	}
+
+	expected := "; Heathrow 27L, CAVOK\r\n" +
+		"Qi138=5\r\n" +
+		"Qs121=0.0174;-0.0052;1.5708;35000000;480000\r\n" +
+		"\r\n" +
+		"Qi242=3\r\n" +
+		"Qi99=1\r\n"
+	if got := write(t, sit); got != expected {
+		t.Errorf("Edited file is %q, expected %q", got, expected)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.situ")
+	if err := sit.Save(path); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if string(saved) != expected {
+		t.Errorf("Saved file is %q", saved)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from, _ := Parse(strings.NewReader(testSituation), testLexicon())
+
+	// the same situation from a version where UplinkBits has moved.
+	moved := psx.NewLexicon(append(testLexicon().Definitions(),
+		psx.MessageDef{MessageType: psx.MsgTypeI, MessageMode: psx.MsgModeXecon, Index: 250, HumanName: "UplinkBits"})...)
+	to, _ := Parse(strings.NewReader("Qi138=6\nQs121=0.0174;-0.0052;1.5708;35000000;480000\nQi250=0\nQi99=1\n"), moved)
+
+	var got []string
+	for _, change := range Diff(from, to) {
+		got = append(got, change.String())
+	}
+	expected := []string{"Brakes added: 1", "FltControls changed: 5 -> 6", "Reserved4 removed: 1"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Diff returned %q, expected %q", got, expected)
+	}
+	if len(Diff(from, from)) != 0 {
+		t.Errorf("Situation differs from itself")
+	}
+}
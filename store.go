@@ -0,0 +1,141 @@
+package psx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StoreEntry is the latest known value of a single variable.
+//
+// Each entry handed out by a Store carries its own copy of the message.
+type StoreEntry struct {
+	Msg     *WireMsg  // the message that set the value
+	Updated time.Time // when the value was received (or sent)
+}
+
+// Store holds the latest value of every Qi/Qs/Qh variable seen on a
+// Connection, effectively mirroring the simulator's state.
+//
+// Enable it with Connection.EnableStore.  A Store is safe to read from any
+// goroutine whilst the Listener updates it.
+type Store struct {
+	mu      sync.RWMutex
+	entries map[string]StoreEntry // keyed by wire key
+	lex     *Lexicon
+}
+
+// initialise a new, empty, Store decoding names with lex
+func newStore(lex *Lexicon) (store *Store) {
+	store = new(Store)
+	store.entries = make(map[string]StoreEntry)
+	store.lex = lex
+	return store
+}
+
+// record msg if it carries a Q variable
+func (store *Store) update(msg *WireMsg) {
+	if !msg.HasValue || !IsQKey(msg.GetKey()) {
+		return
+	}
+	// take a copy so hooks modifying the message don't corrupt the store.
+	msgCopy := *msg
+	entry := StoreEntry{Msg: &msgCopy, Updated: time.Now()}
+
+	store.mu.Lock()
+	store.entries[msg.GetKey()] = entry
+	store.mu.Unlock()
+}
+
+// Returns the latest entry for the variable, which can be named either by
+// its lexicon (human) name or its wire key.
+func (store *Store) Get(name string) (entry StoreEntry, found bool) {
+	key := store.lex.keyFor(name)
+	if key == "" {
+		key = name
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	entry, found = store.entries[key]
+	return entry.copy(), found
+}
+
+// returns the entry with a private copy of the message
+func (entry StoreEntry) copy() StoreEntry {
+	if entry.Msg != nil {
+		msgCopy := *entry.Msg
+		entry.Msg = &msgCopy
+	}
+	return entry
+}
+
+// Returns the latest value of the named variable, or the empty string if it
+// hasn't been seen.
+func (store *Store) Value(name string) string {
+	entry, found := store.Get(name)
+	if !found {
+		return ""
+	}
+	return entry.Msg.Value
+}
+
+// Returns the number of variables in the store.
+func (store *Store) Len() int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return len(store.entries)
+}
+
+// Returns a copy of every entry in the store, keyed by human name (or by wire
+// key, if the lexicon doesn't know it).
+func (store *Store) Snapshot() map[string]StoreEntry {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	snap := make(map[string]StoreEntry, len(store.entries))
+	for key, entry := range store.entries {
+		name := store.lex.humanNameFor(key)
+		if name == "" {
+			name = key
+		}
+		snap[name] = entry.copy()
+	}
+	return snap
+}
+
+// Calls fn for every entry in the store, in name order, until fn returns
+// false.  Entries are named as for Snapshot.
+//
+// fn is called on a snapshot, so it may safely use the Store itself.
+func (store *Store) Range(fn func(name string, entry StoreEntry) bool) {
+	snap := store.Snapshot()
+	names := make([]string, 0, len(snap))
+	for name := range snap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !fn(name, snap[name]) {
+			return
+		}
+	}
+}
+
+// Enable the state Store for this Connection, and return it.
+//
+// Once enabled, the Store records every Q variable received from the server
+// or sent with SendMsg.  Calling EnableStore again returns the same Store.
+func (pconn *Connection) EnableStore() *Store {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	if pconn.store == nil {
+		pconn.store = newStore(pconn.lex)
+	}
+	return pconn.store
+}
+
+// Returns the state Store, or nil if it hasn't been enabled.
+func (pconn *Connection) Store() *Store {
+	pconn.mu.Lock()
+	defer pconn.mu.Unlock()
+	return pconn.store
+}
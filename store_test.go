@@ -0,0 +1,62 @@
+package psx
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("Qi242=41\r\nQi242=42\r\nQh402=7\r\nQs999=unknown\r\nexit\r\n"))
+		io.Copy(io.Discard, c)
+	})
+	pconn, _ := NewConnection(addr, "test")
+	if pconn.Store() != nil {
+		t.Error("Store enabled by default")
+	}
+	store := pconn.EnableStore()
+	if pconn.EnableStore() != store {
+		t.Error("EnableStore didn't return the existing Store")
+	}
+	pconn.Run(context.Background())
+
+	entry, found := store.Get("UplinkBits")
+	if !found {
+		t.Fatal("UplinkBits not found in store")
+	}
+	if entry.Msg.Value != "42" {
+		t.Errorf("Unexpected value %q for UplinkBits", entry.Msg.Value)
+	}
+	if entry.Updated.IsZero() {
+		t.Error("Entry has no timestamp")
+	}
+	if store.Value("Qh402") != "7" {
+		t.Errorf("Unexpected value %q for Qh402", store.Value("Qh402"))
+	}
+	if store.Value("Qs999") != "unknown" {
+		t.Errorf("Unexpected value %q for undecoded Qs999", store.Value("Qs999"))
+	}
+	if _, found := store.Get("load1"); found {
+		t.Error("Store recorded a non-Q message")
+	}
+
+	snap := store.Snapshot()
+	if len(snap) != 3 || store.Len() != 3 {
+		t.Errorf("Unexpected snapshot size %d (store reports %d)", len(snap), store.Len())
+	}
+	if snap["KeybCduC"].Msg.Value != "7" {
+		t.Errorf("Snapshot isn't keyed by human name: %v", snap)
+	}
+
+	var names []string
+	store.Range(func(name string, _ StoreEntry) bool {
+		names = append(names, name)
+		return len(names) < 2
+	})
+	if len(names) != 2 || names[0] != "KeybCduC" || names[1] != "Qs999" {
+		t.Errorf("Unexpected Range order: %v", names)
+	}
+}
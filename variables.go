@@ -0,0 +1,140 @@
+package psx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// A Variables.txt line couldn't be parsed.
+	VariablesSyntaxError = errors.New("Malformed Variables.txt line")
+)
+
+// the Variables.txt mode names, indexed by MsgMode constant
+var msgModeNames = []string{
+	"START", "CONT", "ECON", "DELTA", "BIGMOM", "MCPMOM", "GUAMOM2", "GUAMOM4",
+	"CDUKEYB", "RCP", "ACP", "MIXED", "XDELTA", "XECON", "DEMAND",
+}
+
+// parse a single Variables.txt definition, eg:
+//
+//	Qs121="PiBaHeAlTas"; Mode=ECON; Min=0; Max=0;
+func parseVariable(line string) (msgdef *MessageDef, err error) {
+	eqIdx := strings.Index(line, "=")
+	if eqIdx < 3 {
+		return nil, errors.New("missing key")
+	}
+	msgdef = new(MessageDef)
+	key := line[:eqIdx]
+	switch key[:2] {
+	case "Qi":
+		msgdef.MessageType = MsgTypeI
+	case "Qs":
+		msgdef.MessageType = MsgTypeS
+	case "Qh":
+		msgdef.MessageType = MsgTypeH
+	default:
+		return nil, fmt.Errorf("unknown key type %q", key)
+	}
+	if msgdef.Index, err = strconv.Atoi(key[2:]); err != nil {
+		return nil, fmt.Errorf("bad index %q", key)
+	}
+
+	rest := line[eqIdx+1:]
+	if !strings.HasPrefix(rest, "\"") {
+		return nil, errors.New("name is not quoted")
+	}
+	endQuote := strings.Index(rest[1:], "\"")
+	if endQuote < 0 {
+		return nil, errors.New("unterminated name")
+	}
+	msgdef.HumanName = rest[1 : endQuote+1]
+	if msgdef.HumanName == "" {
+		return nil, errors.New("empty name")
+	}
+
+	foundMode := false
+	for _, attr := range strings.Split(rest[endQuote+2:], ";") {
+		attr = strings.TrimSpace(attr)
+		if !strings.HasPrefix(attr, "Mode=") {
+			// the other attributes (Min, Max, ...) don't affect the
+			// definition.
+			continue
+		}
+		modeName := attr[len("Mode="):]
+		msgdef.MessageMode = -1
+		for mode, name := range msgModeNames {
+			if name == modeName {
+				msgdef.MessageMode = mode
+			}
+		}
+		if msgdef.MessageMode < 0 {
+			return nil, fmt.Errorf("unknown mode %q", modeName)
+		}
+		foundMode = true
+	}
+	if !foundMode {
+		return nil, errors.New("missing mode")
+	}
+	return msgdef, nil
+}
+
+// Parse the variable definitions from a PSX Variables.txt file, producing
+// the same definitions the server sends as its lexicon.
+//
+// Only lines starting with a Q key are definitions - anything else (headers,
+// blank lines) is skipped.
+func ParseVariables(r io.Reader) (defs []MessageDef, err error) {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "Q") {
+			continue
+		}
+		md, err := parseVariable(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", VariablesSyntaxError, lineNo, err)
+		}
+		defs = append(defs, *md)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// Read a Variables.txt file into a Lexicon.
+func LoadVariables(path string) (lex *Lexicon, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defs, err := ParseVariables(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewLexicon(defs...), nil
+}
+
+// Preload the lexicon from a Variables.txt file, so names can be used
+// without the server's lexicon - eg: when working with recorded traffic.
+//
+// As with PreloadLexicon, a live server's lexicon is checked against the
+// preloaded one, and always wins.  Variables.txt doesn't record a server
+// version, so mismatches are reported with an empty CachedVersion.
+func (pconn *Connection) PreloadVariables(path string) error {
+	lex, err := LoadVariables(path)
+	if err != nil {
+		return err
+	}
+	pconn.preload("", lex.definitions())
+	return nil
+}
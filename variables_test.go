@@ -0,0 +1,108 @@
+package psx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testVariables = `PSX Variables
+Version 10.0.0
+
+Qh402="KeybCduC"; Mode=CDUKEYB; Min=0; Max=0;
+Qi242="UplinkBits"; Mode=XECON; Min=0; Max=65535;
+Qs440="TcasData"; Mode=DEMAND;
+`
+
+func TestParseVariables(t *testing.T) {
+	defs, err := ParseVariables(strings.NewReader(testVariables))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	want := []string{"Lh402(K)=KeybCduC", "Li242(Z)=UplinkBits", "Ls440(N)=TcasData"}
+	if len(defs) != len(want) {
+		t.Fatalf("Got %d definitions, expected %d", len(defs), len(want))
+	}
+	for i := range want {
+		// the definitions must match those learned from lexicon lines.
+		md, _ := parseLexicon(parseMsg(nil, want[i]))
+		if defs[i] != *md {
+			t.Errorf("Definition %d is %+v, expected %+v", i, defs[i], *md)
+		}
+	}
+}
+
+func TestParseVariablesErrors(t *testing.T) {
+	for _, line := range []string{
+		`Qx1="Bad"; Mode=ECON;`,
+		`Qi="Bad"; Mode=ECON;`,
+		`Qi1=Bad; Mode=ECON;`,
+		`Qi1="Bad; Mode=ECON;`,
+		`Qi1="Bad"; Mode=SOMETIMES;`,
+		`Qi1="Bad"; Min=0;`,
+	} {
+		_, err := ParseVariables(strings.NewReader(line))
+		if !errors.Is(err, VariablesSyntaxError) {
+			t.Errorf("%s: got %v, expected a syntax error", line, err)
+		}
+	}
+}
+
+func TestPreloadVariables(t *testing.T) {
+	dir := t.TempDir()
+	addr := startFakeServer(t, func(c net.Conn) {
+		sendPreamble(c)
+		c.Write([]byte("exit\r\n"))
+	})
+
+	// UplinkBits has a different mode on the live server.
+	changed := filepath.Join(dir, "changed.txt")
+	os.WriteFile(changed, []byte("Qh402=\"KeybCduC\"; Mode=CDUKEYB;\nQi242=\"UplinkBits\"; Mode=ECON;\n"), 0644)
+	pconn, _ := NewConnection(addr, "test")
+	if err := pconn.PreloadVariables(changed); err != nil {
+		t.Fatalf("Preload failed: %s", err)
+	}
+	var mismatches []LexiconMismatch
+	pconn.OnLexiconMismatch = func(_ *Connection, mismatch LexiconMismatch) {
+		mismatches = append(mismatches, mismatch)
+	}
+	if err := pconn.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if len(mismatches) != 1 {
+		t.Fatalf("Got mismatches %v, expected 1", mismatches)
+	}
+	m := mismatches[0]
+	if m.CachedVersion != "" || m.Version != "10.0.0" || m.Cached.LexiconString() != "Li242(E)=UplinkBits" || m.Live.LexiconString() != "Li242(Z)=UplinkBits" {
+		t.Errorf("Unexpected mismatch %+v", m)
+	}
+	if m.String() != "Li242(E)=UplinkBits changed to Li242(Z)=UplinkBits in 10.0.0" {
+		t.Errorf("Mismatch formatted as %q", m.String())
+	}
+
+	// a cache that matches the server mustn't be rewritten just because the
+	// preloaded variables carry no version.
+	same := filepath.Join(dir, "same.txt")
+	os.WriteFile(same, []byte("Qh402=\"KeybCduC\"; Mode=CDUKEYB;\nQi242=\"UplinkBits\"; Mode=XECON;\n"), 0644)
+	cachePath := filepath.Join(dir, "lexicon.txt")
+	cached := "version=10.0.0\n\nLh402(K)=KeybCduC\nLi242(Z)=UplinkBits\n"
+	os.WriteFile(cachePath, []byte(cached), 0644)
+	pconn, _ = NewConnection(addr, "test")
+	pconn.UseLexiconCache(cachePath)
+	if err := pconn.PreloadVariables(same); err != nil {
+		t.Fatalf("Preload failed: %s", err)
+	}
+	pconn.OnLexiconMismatch = func(_ *Connection, mismatch LexiconMismatch) {
+		t.Errorf("Unexpected mismatch %s", mismatch)
+	}
+	if err := pconn.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if saved, _ := os.ReadFile(cachePath); string(saved) != cached {
+		t.Errorf("Cache was rewritten:\n%s", saved)
+	}
+}
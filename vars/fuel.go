@@ -0,0 +1,44 @@
+package vars
+
+import "github.com/kuroneko/psx.go"
+
+// The variable holding the fuel tank quantities.
+const FuelVar = "FuelQty"
+
+// Fuel is the decoded FuelQty variable.  Quantities are in pounds, to the
+// tenth.
+type Fuel struct {
+	Main1    float64 `psx:"0,deci"`
+	Main2    float64 `psx:"1,deci"`
+	Main3    float64 `psx:"2,deci"`
+	Main4    float64 `psx:"3,deci"`
+	Reserve2 float64 `psx:"4,deci"`
+	Reserve3 float64 `psx:"5,deci"`
+	Center   float64 `psx:"6,deci"`
+	Stab     float64 `psx:"7,deci"`
+}
+
+// Returns the total fuel on board in pounds.
+func (f Fuel) Total() float64 {
+	return f.Main1 + f.Main2 + f.Main3 + f.Main4 + f.Reserve2 + f.Reserve3 + f.Center + f.Stab
+}
+
+// Returns the total fuel on board in kilograms.
+func (f Fuel) TotalKg() float64 {
+	return f.Total() * kgPerLb
+}
+
+// Decode a FuelQty message.
+func DecodeFuel(msg *psx.WireMsg) (fuel Fuel, err error) {
+	err = msg.Unmarshal(&fuel)
+	return fuel, err
+}
+
+// Call fn with every fuel update received on pconn.
+func OnFuel(pconn *psx.Connection, fn func(Fuel)) psx.HookID {
+	return pconn.AddHook(FuelVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if fuel, err := DecodeFuel(msg); err == nil {
+			fn(fuel)
+		}
+	})
+}
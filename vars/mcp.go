@@ -0,0 +1,33 @@
+package vars
+
+import "github.com/kuroneko/psx.go"
+
+// The variable holding the MCP window values.
+const MCPVar = "McpDisplays"
+
+// MCP is the decoded McpDisplays variable - the values set in the mode
+// control panel windows.
+type MCP struct {
+	IAS           int     `psx:"0"`          // selected speed, knots
+	Mach          float64 `psx:"1,milli"`    // selected Mach number
+	MachSelected  bool    `psx:"2"`          // the speed window shows Mach
+	Heading       int     `psx:"3"`          // selected heading, degrees
+	Altitude      int     `psx:"4"`          // selected altitude, feet
+	VerticalSpeed int     `psx:"5"`          // selected vertical speed, feet/min
+	VSBlank       bool    `psx:"6,optional"` // the vertical speed window is blank
+}
+
+// Decode a McpDisplays message.
+func DecodeMCP(msg *psx.WireMsg) (mcp MCP, err error) {
+	err = msg.Unmarshal(&mcp)
+	return mcp, err
+}
+
+// Call fn with every MCP update received on pconn.
+func OnMCP(pconn *psx.Connection, fn func(MCP)) psx.HookID {
+	return pconn.AddHook(MCPVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if mcp, err := DecodeMCP(msg); err == nil {
+			fn(mcp)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package vars
+
+import "github.com/kuroneko/psx.go"
+
+// The variable holding the aircraft's attitude and position.
+const PositionVar = "PiBaHeAlTas"
+
+// Position is the decoded PiBaHeAlTas variable.  Angles are kept in radians
+// as PSX sends them - use the Deg methods for degrees.
+type Position struct {
+	Pitch     float64 `psx:"0"`       // radians
+	Bank      float64 `psx:"1"`       // radians
+	Heading   float64 `psx:"2"`       // true heading, radians
+	Altitude  float64 `psx:"3,milli"` // feet
+	TAS       float64 `psx:"4,milli"` // true airspeed, knots
+	Latitude  float64 `psx:"5"`       // radians, north positive
+	Longitude float64 `psx:"6"`       // radians, east positive
+}
+
+// Returns the pitch in degrees.
+func (p Position) PitchDeg() float64 {
+	return toDeg(p.Pitch)
+}
+
+// Returns the bank in degrees.
+func (p Position) BankDeg() float64 {
+	return toDeg(p.Bank)
+}
+
+// Returns the true heading in degrees, normalised to [0, 360).
+func (p Position) HeadingDeg() float64 {
+	deg := toDeg(p.Heading)
+	for deg < 0 {
+		deg += 360
+	}
+	for deg >= 360 {
+		deg -= 360
+	}
+	return deg
+}
+
+// Returns the latitude in degrees.
+func (p Position) LatitudeDeg() float64 {
+	return toDeg(p.Latitude)
+}
+
+// Returns the longitude in degrees.
+func (p Position) LongitudeDeg() float64 {
+	return toDeg(p.Longitude)
+}
+
+// Decode a PiBaHeAlTas message.
+func DecodePosition(msg *psx.WireMsg) (pos Position, err error) {
+	err = msg.Unmarshal(&pos)
+	return pos, err
+}
+
+// Call fn with every position update received on pconn.
+func OnPosition(pconn *psx.Connection, fn func(Position)) psx.HookID {
+	return pconn.AddHook(PositionVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if pos, err := DecodePosition(msg); err == nil {
+			fn(pos)
+		}
+	})
+}
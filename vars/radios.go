@@ -0,0 +1,49 @@
+package vars
+
+import (
+	"fmt"
+
+	"github.com/kuroneko/psx.go"
+)
+
+// The variable holding the VHF radio frequencies.
+const RadiosVar = "FreqsVhf"
+
+// Frequency is a radio frequency in kHz.
+type Frequency int
+
+// Returns the frequency in MHz.
+func (f Frequency) MHz() float64 {
+	return float64(f) / 1000.0
+}
+
+// Formats the frequency in MHz as shown on the radio panel, eg: 118.705
+func (f Frequency) String() string {
+	return fmt.Sprintf("%d.%03d", f/1000, f%1000)
+}
+
+// Radios is the decoded FreqsVhf variable - the active and standby
+// frequencies of the three VHF radios.
+type Radios struct {
+	LeftActive    Frequency `psx:"0"`
+	LeftStandby   Frequency `psx:"1"`
+	CenterActive  Frequency `psx:"2"`
+	CenterStandby Frequency `psx:"3"`
+	RightActive   Frequency `psx:"4"`
+	RightStandby  Frequency `psx:"5"`
+}
+
+// Decode a FreqsVhf message.
+func DecodeRadios(msg *psx.WireMsg) (radios Radios, err error) {
+	err = msg.Unmarshal(&radios)
+	return radios, err
+}
+
+// Call fn with every radio update received on pconn.
+func OnRadios(pconn *psx.Connection, fn func(Radios)) psx.HookID {
+	return pconn.AddHook(RadiosVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if radios, err := DecodeRadios(msg); err == nil {
+			fn(radios)
+		}
+	})
+}
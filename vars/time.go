@@ -0,0 +1,41 @@
+package vars
+
+import (
+	"time"
+
+	"github.com/kuroneko/psx.go"
+)
+
+// The variable holding the simulated time of day.
+const TimeVar = "TimeEarth"
+
+// TimeOfDay is the decoded TimeEarth variable - the simulator's UTC clock,
+// which need not match the wall clock.
+type TimeOfDay struct {
+	Millis int64 `psx:"0"` // milliseconds since the Unix epoch, UTC
+}
+
+// Returns the simulated time as a time.Time in UTC.
+func (t TimeOfDay) Time() time.Time {
+	return time.UnixMilli(t.Millis).UTC()
+}
+
+// Returns the simulated UTC hour, minute and second.
+func (t TimeOfDay) Clock() (hour, min, sec int) {
+	return t.Time().Clock()
+}
+
+// Decode a TimeEarth message.
+func DecodeTimeOfDay(msg *psx.WireMsg) (tod TimeOfDay, err error) {
+	err = msg.Unmarshal(&tod)
+	return tod, err
+}
+
+// Call fn with every time of day update received on pconn.
+func OnTimeOfDay(pconn *psx.Connection, fn func(TimeOfDay)) psx.HookID {
+	return pconn.AddHook(TimeVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if tod, err := DecodeTimeOfDay(msg); err == nil {
+			fn(tod)
+		}
+	})
+}
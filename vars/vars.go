@@ -0,0 +1,26 @@
+// Provides Go types for commonly used PSX variables, so addons can work in
+// proper units instead of splitting and scaling raw values by hand.
+//
+// Each model has a Decode function that turns a *psx.WireMsg into the typed
+// value, and an On function that registers a hook on a psx.Connection:
+//
+//	vars.OnPosition(pconn, func(pos vars.Position) {
+//		fmt.Printf("Heading %.0f\n", pos.HeadingDeg())
+//	})
+//
+// The On functions don't subscribe to the variable - if you use
+// Router/SwitchPSX notify filtering, Subscribe to the variable names (see
+// the *Var constants) as well.
+//
+// The field layouts are hand maintained from Variables.txt.
+package vars
+
+import "math"
+
+// radians to degrees
+func toDeg(rad float64) float64 {
+	return rad * 180.0 / math.Pi
+}
+
+// pounds to kilograms
+const kgPerLb = 0.45359237
@@ -0,0 +1,94 @@
+package vars
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kuroneko/psx.go"
+	"github.com/kuroneko/psx.go/psxtest"
+)
+
+const testPosition = "0.1;0;-1.5707963267948966;35000500;250400;0.5;-1.5"
+
+func TestDecodePosition(t *testing.T) {
+	pconn, _ := psx.NewConnection("localhost:0", "test")
+	pos, err := DecodePosition(pconn.NewPair(PositionVar, testPosition))
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if math.Abs(pos.HeadingDeg()-270.0) > 1e-9 {
+		t.Errorf("Heading %f, expected 270", pos.HeadingDeg())
+	}
+	if math.Abs(pos.PitchDeg()-5.729578) > 1e-6 || math.Abs(pos.LatitudeDeg()-28.647890) > 1e-6 {
+		t.Errorf("Bad angle conversion: %+v", pos)
+	}
+	if pos.Altitude != 35000.5 || pos.TAS != 250.4 {
+		t.Errorf("Bad scaling: %+v", pos)
+	}
+
+	if _, err := DecodePosition(pconn.NewPair(PositionVar, "0.1;0")); err == nil {
+		t.Errorf("Short position decoded without error")
+	}
+}
+
+func TestDecodeModels(t *testing.T) {
+	pconn, _ := psx.NewConnection("localhost:0", "test")
+
+	radios, err := DecodeRadios(pconn.NewPair(RadiosVar, "118705;121500;131550;122800;118000;119100"))
+	if err != nil {
+		t.Fatalf("Radios decode failed: %s", err)
+	}
+	if radios.LeftActive.String() != "118.705" || radios.RightStandby.MHz() != 119.1 {
+		t.Errorf("Bad radios: %+v", radios)
+	}
+
+	fuel, err := DecodeFuel(pconn.NewPair(FuelVar, "100;200;300;400;50;50;1000;0"))
+	if err != nil {
+		t.Fatalf("Fuel decode failed: %s", err)
+	}
+	if fuel.Total() != 210.0 {
+		t.Errorf("Fuel total %f, expected 210", fuel.Total())
+	}
+
+	tod, err := DecodeTimeOfDay(pconn.NewPair(TimeVar, "1700000000000"))
+	if err != nil {
+		t.Fatalf("Time decode failed: %s", err)
+	}
+	if h, m, s := tod.Clock(); h != 22 || m != 13 || s != 20 {
+		t.Errorf("Clock %02d:%02d:%02d, expected 22:13:20", h, m, s)
+	}
+
+	if weatherZone(WeatherVar(3)) != 3 || weatherZone(WeatherVar(0)) != 0 || weatherZone("WxZoneX") != 0 {
+		t.Errorf("Weather zone names don't round trip")
+	}
+}
+
+func TestOnPosition(t *testing.T) {
+	srv, err := psxtest.NewServer("10.0.0",
+		psx.MessageDef{MessageType: psx.MsgTypeS, MessageMode: psx.MsgModeEcon, Index: 121, HumanName: PositionVar})
+	if err != nil {
+		t.Fatalf("Couldn't start server: %s", err)
+	}
+	defer srv.Close()
+	srv.Set(PositionVar, testPosition)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pconn, _ := psx.NewConnection(srv.Addr(), "test")
+	positions := make(chan Position, 10)
+	OnPosition(pconn, func(pos Position) {
+		positions <- pos
+	})
+	go pconn.Run(ctx)
+
+	select {
+	case pos := <-positions:
+		if math.Abs(pos.HeadingDeg()-270.0) > 1e-9 {
+			t.Errorf("Heading %f, expected 270", pos.HeadingDeg())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Position was never delivered")
+	}
+}
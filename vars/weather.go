@@ -0,0 +1,67 @@
+package vars
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kuroneko/psx.go"
+)
+
+// PSX models this many weather zones, numbered from 1.
+const WeatherZones = 7
+
+// the variable name prefix for the weather zones
+const weatherPrefix = "WxZone"
+
+// Returns the name of the variable holding the numbered weather zone.
+func WeatherVar(zone int) string {
+	return weatherPrefix + strconv.Itoa(zone)
+}
+
+// returns the zone number for a weather zone variable name, or 0 if it isn't
+// one.
+func weatherZone(name string) int {
+	if !strings.HasPrefix(name, weatherPrefix) {
+		return 0
+	}
+	zone, err := strconv.Atoi(name[len(weatherPrefix):])
+	if err != nil || zone < 1 || zone > WeatherZones {
+		return 0
+	}
+	return zone
+}
+
+// Weather is a decoded weather zone variable.
+type Weather struct {
+	WindDirection int     `psx:"0"`       // degrees true
+	WindSpeed     int     `psx:"1"`       // knots
+	Temperature   float64 `psx:"2,deci"`  // surface temperature, degrees C
+	QNH           float64 `psx:"3,centi"` // inches of mercury
+	Visibility    int     `psx:"4"`       // metres
+	CloudBase     int     `psx:"5"`       // lowest cloud base, feet
+	Turbulence    int     `psx:"6"`       // 0 (none) to 10 (severe)
+}
+
+// Returns the QNH in hectopascals.
+func (w Weather) QNHHpa() float64 {
+	return w.QNH * 33.8639
+}
+
+// Decode a weather zone message.
+func DecodeWeather(msg *psx.WireMsg) (wx Weather, err error) {
+	err = msg.Unmarshal(&wx)
+	return wx, err
+}
+
+// Call fn with every weather zone update received on pconn, along with the
+// zone number.
+func OnWeather(pconn *psx.Connection, fn func(zone int, wx Weather)) psx.HookID {
+	match := func(msg *psx.WireMsg) bool {
+		return weatherZone(msg.GetDecodedKey()) != 0
+	}
+	return pconn.AddPredicateHook(match, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if wx, err := DecodeWeather(msg); err == nil {
+			fn(weatherZone(msg.GetDecodedKey()), wx)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package vars
+
+import "github.com/kuroneko/psx.go"
+
+// The variable holding the aircraft weights and balance.
+const WeightsVar = "GwCgZfw"
+
+// Weights is the decoded GwCgZfw variable.
+type Weights struct {
+	GrossWeight    int     `psx:"0"`      // pounds
+	CG             float64 `psx:"1,deci"` // percent MAC
+	ZeroFuelWeight int     `psx:"2"`      // pounds
+}
+
+// Returns the gross weight in kilograms.
+func (w Weights) GrossWeightKg() float64 {
+	return float64(w.GrossWeight) * kgPerLb
+}
+
+// Returns the zero fuel weight in kilograms.
+func (w Weights) ZeroFuelWeightKg() float64 {
+	return float64(w.ZeroFuelWeight) * kgPerLb
+}
+
+// Decode a GwCgZfw message.
+func DecodeWeights(msg *psx.WireMsg) (weights Weights, err error) {
+	err = msg.Unmarshal(&weights)
+	return weights, err
+}
+
+// Call fn with every weights update received on pconn.
+func OnWeights(pconn *psx.Connection, fn func(Weights)) psx.HookID {
+	return pconn.AddHook(WeightsVar, func(_ *psx.Connection, msg *psx.WireMsg) {
+		if weights, err := DecodeWeights(msg); err == nil {
+			fn(weights)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package psx
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy decides what a Watch does when its consumer falls behind
+// and the channel buffer is full.
+type OverflowPolicy int
+
+const (
+	// Wait for the consumer.  No messages are lost, but the Listener stalls
+	// until there is room.
+	OverflowBlock OverflowPolicy = iota
+	// Discard the oldest buffered message to make room for the new one.
+	OverflowDropOldest
+	// Keep only the latest undelivered message for each key.  Messages
+	// are delivered in the order their keys first became pending.  The
+	// Buffer size is ignored - coalescing replaces buffering.
+	OverflowCoalesce
+)
+
+// WatchOptions configure a channel subscription created with
+// WatchWithOptions.
+type WatchOptions struct {
+	Buffer   int            // channel buffer size
+	Overflow OverflowPolicy // what to do once the buffer is full
+}
+
+// The options used by Watch.
+var DefaultWatchOptions = WatchOptions{
+	Buffer:   64,
+	Overflow: OverflowBlock,
+}
+
+// Deliver messages for the given keys (lexicon names or wire keys) on a
+// channel, using DefaultWatchOptions.  With no keys, every message is
+// delivered.
+//
+// The channel is closed once ctx is cancelled.
+func (pconn *Connection) Watch(ctx context.Context, keys ...string) <-chan *WireMsg {
+	return pconn.WatchWithOptions(ctx, DefaultWatchOptions, keys...)
+}
+
+// Deliver messages for the given keys (lexicon names or wire keys) on a
+// channel, buffered and handling overflow according to opts.  With no keys,
+// every message is delivered.
+//
+// Each message delivered is a copy, so it can safely be used from the
+// receiving goroutine.  The channel is closed once ctx is cancelled.
+func (pconn *Connection) WatchWithOptions(ctx context.Context, opts WatchOptions, keys ...string) <-chan *WireMsg {
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
+	}
+	if opts.Overflow == OverflowDropOldest && opts.Buffer == 0 {
+		// there must be somewhere to keep the newest message.
+		opts.Buffer = 1
+	}
+	w := &watcher{ctx: ctx, opts: opts}
+	if opts.Overflow == OverflowCoalesce {
+		w.out = make(chan *WireMsg)
+		w.pending = make(map[string]*WireMsg)
+		w.wake = make(chan struct{}, 1)
+		go w.pump()
+	} else {
+		w.out = make(chan *WireMsg, opts.Buffer)
+	}
+
+	var match MessagePredicate
+	if len(keys) == 0 {
+		match = func(*WireMsg) bool { return true }
+	} else {
+		keySet := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			keySet[key] = true
+		}
+		match = func(msg *WireMsg) bool {
+			return keySet[msg.GetDecodedKey()] || keySet[msg.GetKey()]
+		}
+	}
+	id := pconn.AddPredicateHook(match, w.deliver)
+
+	go func() {
+		<-ctx.Done()
+		pconn.RemoveHook(id)
+		w.mu.Lock()
+		w.closed = true
+		if opts.Overflow != OverflowCoalesce {
+			// the pump closes the channel itself for coalescing watches.
+			close(w.out)
+		}
+		w.mu.Unlock()
+	}()
+	return w.out
+}
+
+// the state of a single Watch
+type watcher struct {
+	ctx  context.Context
+	opts WatchOptions
+	out  chan *WireMsg
+
+	mu     sync.Mutex
+	closed bool
+
+	// coalescing state, guarded by mu.
+	pending map[string]*WireMsg // latest undelivered message by key
+	order   []string            // pending keys in delivery order
+	wake    chan struct{}       // pokes the pump when something is pending
+}
+
+// the hook that hands each matching message to the watcher
+func (w *watcher) deliver(_ *Connection, msg *WireMsg) {
+	msgCopy := *msg
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	switch w.opts.Overflow {
+	case OverflowBlock:
+		select {
+		case w.out <- &msgCopy:
+		case <-w.ctx.Done():
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case w.out <- &msgCopy:
+				return
+			default:
+			}
+			// full - throw away the oldest, if the consumer hasn't
+			// beaten us to it, and try again.
+			select {
+			case <-w.out:
+			default:
+			}
+		}
+	case OverflowCoalesce:
+		key := msg.GetKey()
+		if _, found := w.pending[key]; !found {
+			w.order = append(w.order, key)
+		}
+		w.pending[key] = &msgCopy
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// deliver coalesced messages to the consumer, closing the channel once the
+// context is done.
+func (w *watcher) pump() {
+	defer close(w.out)
+	for {
+		w.mu.Lock()
+		var next *WireMsg
+		if len(w.order) > 0 {
+			next = w.pending[w.order[0]]
+			delete(w.pending, w.order[0])
+			w.order = w.order[1:]
+		}
+		w.mu.Unlock()
+
+		if next == nil {
+			select {
+			case <-w.wake:
+				continue
+			case <-w.ctx.Done():
+				return
+			}
+		}
+		select {
+		case w.out <- next:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
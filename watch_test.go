@@ -0,0 +1,102 @@
+package psx
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// feed lines through the hooks as the Listener would.
+func feedLines(pconn *Connection, lines ...string) {
+	for _, line := range lines {
+		msg := parseMsg(pconn.lex, line)
+		pconn.callHook(msg.GetDecodedKey(), msg)
+	}
+}
+
+func newWatchConnection() *Connection {
+	pconn, _ := NewConnection("localhost:10747", "test")
+	pconn.lex.parse(parseMsg(nil, "Lh402(K)=KeybCduC"))
+	pconn.lex.parse(parseMsg(nil, "Li242(Z)=UplinkBits"))
+	return pconn
+}
+
+func receive(t *testing.T, ch <-chan *WireMsg) *WireMsg {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for watched message")
+	}
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	pconn := newWatchConnection()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := pconn.Watch(ctx, "UplinkBits", "Qh402")
+
+	feedLines(pconn, "Qi242=1", "Qs1=ignored", "Qh402=2")
+	if msg := receive(t, ch); msg.String() != "UplinkBits=1" {
+		t.Errorf("Unexpected message %s", msg)
+	}
+	if msg := receive(t, ch); msg.String() != "KeybCduC=2" {
+		t.Errorf("Unexpected message %s", msg)
+	}
+
+	cancel()
+	select {
+	case _, open := <-ch:
+		if open {
+			t.Error("Received unexpected message after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Channel wasn't closed on cancel")
+	}
+	// the hook must be gone, so this mustn't block or panic.
+	feedLines(pconn, "Qi242=3")
+}
+
+func TestWatchDropOldest(t *testing.T) {
+	pconn := newWatchConnection()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := pconn.WatchWithOptions(ctx, WatchOptions{Buffer: 2, Overflow: OverflowDropOldest}, "UplinkBits")
+
+	for i := 1; i <= 5; i++ {
+		feedLines(pconn, "Qi242="+strconv.Itoa(i))
+	}
+	if msg := receive(t, ch); msg.Value != "4" {
+		t.Errorf("Expected oldest surviving value 4, got %s", msg.Value)
+	}
+	if msg := receive(t, ch); msg.Value != "5" {
+		t.Errorf("Expected latest value 5, got %s", msg.Value)
+	}
+}
+
+func TestWatchCoalesce(t *testing.T) {
+	pconn := newWatchConnection()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := pconn.WatchWithOptions(ctx, WatchOptions{Overflow: OverflowCoalesce})
+
+	for i := 1; i <= 10; i++ {
+		feedLines(pconn, "Qi242="+strconv.Itoa(i))
+	}
+	feedLines(pconn, "Qh402=1")
+
+	// the pump may have grabbed an early value before the rest arrived,
+	// but everything else must have coalesced.
+	received := 0
+	latest := make(map[string]string)
+	for latest["UplinkBits"] != "10" || latest["KeybCduC"] != "1" {
+		msg := receive(t, ch)
+		latest[msg.GetDecodedKey()] = msg.Value
+		received++
+	}
+	if received > 3 {
+		t.Errorf("Expected updates to coalesce, received %d messages", received)
+	}
+}
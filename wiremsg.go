@@ -21,11 +21,11 @@ type WireMsg struct {
 	Value    string // Value of the data section (right hand side)
 
 	definition *MessageDef // cached message defintion for this WireMsg
-	lexicon    *lexicon
+	lexicon    *Lexicon
 }
 
 // Initialise a new (blank) WireMsg
-func newWireMsg(lex *lexicon) (msg *WireMsg) {
+func newWireMsg(lex *Lexicon) (msg *WireMsg) {
 	msg = new(WireMsg)
 	msg.lexicon = lex
 
@@ -33,7 +33,7 @@ func newWireMsg(lex *lexicon) (msg *WireMsg) {
 }
 
 // Parse a line of input from the server and return it in WireMsg form
-func parseMsg(lex *lexicon, line string) (msg *WireMsg) {
+func parseMsg(lex *Lexicon, line string) (msg *WireMsg) {
 	msg = newWireMsg(lex)
 	msg.Parse(line)
 	return msg
@@ -44,7 +44,7 @@ func parseMsg(lex *lexicon, line string) (msg *WireMsg) {
 //    retry it)
 func (msg *WireMsg) relinkKey() {
 	if msg.lexicon != nil {
-		msg.definition, _ = msg.lexicon.forward[msg.key]
+		msg.definition = msg.lexicon.lookupKey(msg.key)
 	} else {
 		msg.definition = nil
 	}
@@ -86,7 +86,8 @@ func (msg *WireMsg) SetDecodedKey(humanName string) {
 	var def *MessageDef = nil
 
 	if msg.lexicon != nil {
-		def, found = msg.lexicon.reverse[humanName]
+		def = msg.lexicon.lookupName(humanName)
+		found = def != nil
 		if found {
 			msg.definition = def
 			msg.key = def.KeyString()
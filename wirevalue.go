@@ -0,0 +1,192 @@
+package psx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// The message has no value to decode.
+	NoValueError = errors.New("Message has no value")
+	// The value was requested as a type the message's definition doesn't
+	// allow (eg: an integer from a Qs string).
+	ValueTypeError = errors.New("Message type can't be decoded as requested")
+	// The requested field isn't present in the value.
+	FieldRangeError = errors.New("Field index out of range")
+)
+
+// ValueError reports a WireMsg value that couldn't be decoded.
+type ValueError struct {
+	Key   string // the (decoded) key of the message
+	Value string // the value, or field, that couldn't be decoded
+	Want  string // what it was being decoded as
+	Err   error  // the underlying error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("%s: can't decode %q as %s: %s", e.Key, e.Value, e.Want, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// build a ValueError for this message.  strconv errors are unwrapped to their
+// cause as the ValueError already carries the value.
+func (msg *WireMsg) valueError(value, want string, err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = numErr.Err
+	}
+	return &ValueError{Key: msg.GetDecodedKey(), Value: value, Want: want, Err: err}
+}
+
+// check the message can be decoded as an integer - Qi and Qh messages can,
+// Qs can't.  Messages without a known definition are given the benefit of
+// the doubt.
+func (msg *WireMsg) checkIntegral(want string) error {
+	if !msg.HasValue {
+		return msg.valueError("", want, NoValueError)
+	}
+	def := msg.GetDefinition()
+	if def != nil && def.MessageType == MsgTypeS {
+		return msg.valueError(msg.Value, want, ValueTypeError)
+	}
+	return nil
+}
+
+// Decode the value as an int.  Only valid for Qi and Qh messages.
+func (msg *WireMsg) Int() (int, error) {
+	if err := msg.checkIntegral("int"); err != nil {
+		return 0, err
+	}
+	val, err := strconv.Atoi(msg.Value)
+	if err != nil {
+		return 0, msg.valueError(msg.Value, "int", err)
+	}
+	return val, nil
+}
+
+// Decode the value as an int64.  Only valid for Qi and Qh messages.
+func (msg *WireMsg) Int64() (int64, error) {
+	if err := msg.checkIntegral("int64"); err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseInt(msg.Value, 10, 64)
+	if err != nil {
+		return 0, msg.valueError(msg.Value, "int64", err)
+	}
+	return val, nil
+}
+
+// Decode the value as a bool - zero is false, anything else is true.  Only
+// valid for Qi and Qh messages.
+func (msg *WireMsg) Bool() (bool, error) {
+	if err := msg.checkIntegral("bool"); err != nil {
+		return false, err
+	}
+	val, err := strconv.ParseInt(msg.Value, 10, 64)
+	if err != nil {
+		return false, msg.valueError(msg.Value, "bool", err)
+	}
+	return val != 0, nil
+}
+
+// Decode the whole value as a float64.
+func (msg *WireMsg) Float() (float64, error) {
+	if !msg.HasValue {
+		return 0, msg.valueError("", "float", NoValueError)
+	}
+	val, err := strconv.ParseFloat(msg.Value, 64)
+	if err != nil {
+		return 0, msg.valueError(msg.Value, "float", err)
+	}
+	return val, nil
+}
+
+// Returns the ; delimited fields of the value, or nil if there's no value.
+func (msg *WireMsg) Fields() []string {
+	if !msg.HasValue {
+		return nil
+	}
+	return strings.Split(msg.Value, ";")
+}
+
+// fetch the numbered field for decoding as want.
+func (msg *WireMsg) field(idx int, want string) (string, error) {
+	if !msg.HasValue {
+		return "", msg.valueError("", want, NoValueError)
+	}
+	if idx < 0 {
+		return "", msg.valueError(msg.Value, want, FieldRangeError)
+	}
+	val, found := msg.ValueAtSubIndex(idx)
+	if !found {
+		return "", msg.valueError(msg.Value, want, FieldRangeError)
+	}
+	return val, nil
+}
+
+// Decode the numbered ; delimited field of the value as a float64.
+func (msg *WireMsg) FieldAsFloat(idx int) (float64, error) {
+	want := "float (field " + strconv.Itoa(idx) + ")"
+	field, err := msg.field(idx, want)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, msg.valueError(field, want, err)
+	}
+	return val, nil
+}
+
+// Decode the numbered ; delimited field of the value as an int64.
+func (msg *WireMsg) FieldAsInt(idx int) (int64, error) {
+	want := "int64 (field " + strconv.Itoa(idx) + ")"
+	field, err := msg.field(idx, want)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return 0, msg.valueError(field, want, err)
+	}
+	return val, nil
+}
+
+// Set the value to a string.
+func (msg *WireMsg) SetString(val string) {
+	msg.HasValue = true
+	msg.Value = val
+}
+
+// Set the value to an int.
+func (msg *WireMsg) SetInt(val int) {
+	msg.SetString(strconv.Itoa(val))
+}
+
+// Set the value to an int64.
+func (msg *WireMsg) SetInt64(val int64) {
+	msg.SetString(strconv.FormatInt(val, 10))
+}
+
+// Set the value to a bool, encoded as 1 or 0.
+func (msg *WireMsg) SetBool(val bool) {
+	if val {
+		msg.SetString("1")
+	} else {
+		msg.SetString("0")
+	}
+}
+
+// Set the value to a float64, in plain (non-exponent) notation.
+func (msg *WireMsg) SetFloat(val float64) {
+	msg.SetString(strconv.FormatFloat(val, 'f', -1, 64))
+}
+
+// Set the value to the given fields, ; delimited.
+func (msg *WireMsg) SetFields(fields ...string) {
+	msg.SetString(strings.Join(fields, ";"))
+}
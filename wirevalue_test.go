@@ -0,0 +1,94 @@
+package psx
+
+import (
+	"errors"
+	"testing"
+)
+
+func newValueLexicon() *Lexicon {
+	lex := NewLexicon()
+	lex.parse(parseMsg(nil, "Li242(Z)=UplinkBits"))
+	lex.parse(parseMsg(nil, "Ls121(E)=PiBaHeAlTas"))
+	return lex
+}
+
+func TestTypedValues(t *testing.T) {
+	lex := newValueLexicon()
+
+	msg := parseMsg(lex, "Qi242=42")
+	if val, err := msg.Int(); err != nil || val != 42 {
+		t.Errorf("Int() returned %d, %v", val, err)
+	}
+	if val, err := msg.Int64(); err != nil || val != 42 {
+		t.Errorf("Int64() returned %d, %v", val, err)
+	}
+	if val, err := msg.Bool(); err != nil || !val {
+		t.Errorf("Bool() returned %t, %v", val, err)
+	}
+	if val, err := msg.Float(); err != nil || val != 42.0 {
+		t.Errorf("Float() returned %f, %v", val, err)
+	}
+
+	msg = parseMsg(lex, "Qs121=0.1;-0.2;3.14;35000123;250000;0.5;-1.5")
+	if fields := msg.Fields(); len(fields) != 7 || fields[2] != "3.14" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if val, err := msg.FieldAsFloat(2); err != nil || val != 3.14 {
+		t.Errorf("FieldAsFloat(2) returned %f, %v", val, err)
+	}
+	if val, err := msg.FieldAsInt(3); err != nil || val != 35000123 {
+		t.Errorf("FieldAsInt(3) returned %d, %v", val, err)
+	}
+}
+
+func TestTypedValueErrors(t *testing.T) {
+	lex := newValueLexicon()
+
+	msg := parseMsg(lex, "Qs121=0.1;x;3")
+	if _, err := msg.Int(); !errors.Is(err, ValueTypeError) {
+		t.Errorf("Int() on a Qs returned %v", err)
+	}
+	if _, err := msg.FieldAsFloat(7); !errors.Is(err, FieldRangeError) {
+		t.Errorf("FieldAsFloat(7) returned %v", err)
+	}
+	_, err := msg.FieldAsFloat(1)
+	var valueErr *ValueError
+	if !errors.As(err, &valueErr) {
+		t.Fatalf("FieldAsFloat(1) returned %v", err)
+	}
+	if valueErr.Key != "PiBaHeAlTas" || valueErr.Value != "x" {
+		t.Errorf("Unexpected error detail: %s", err)
+	}
+
+	msg = parseMsg(lex, "Qi242=forty")
+	if _, err := msg.Int(); err == nil {
+		t.Error("Int() accepted a malformed value")
+	}
+	msg = parseMsg(lex, "load1")
+	if _, err := msg.Float(); !errors.Is(err, NoValueError) {
+		t.Errorf("Float() without a value returned %v", err)
+	}
+}
+
+func TestTypedSetters(t *testing.T) {
+	msg := newWireMsg(newValueLexicon())
+	msg.SetDecodedKey("UplinkBits")
+	msg.SetInt(7)
+	if msg.WireString() != "Qi242=7" {
+		t.Errorf("SetInt produced %s", msg.WireString())
+	}
+	msg.SetBool(false)
+	if msg.WireString() != "Qi242=0" {
+		t.Errorf("SetBool produced %s", msg.WireString())
+	}
+
+	msg.SetDecodedKey("PiBaHeAlTas")
+	msg.SetFloat(0.000001)
+	if msg.Value != "0.000001" {
+		t.Errorf("SetFloat produced %s", msg.Value)
+	}
+	msg.SetFields("1", "2", "3")
+	if msg.WireString() != "Qs121=1;2;3" {
+		t.Errorf("SetFields produced %s", msg.WireString())
+	}
+}